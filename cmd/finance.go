@@ -2,32 +2,82 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/StatCan/namespace-controller/pkg/controllers/namespaces"
+	financeconfig "github.com/StatCan/namespace-controller/pkg/finance/config"
+	financemetrics "github.com/StatCan/namespace-controller/pkg/finance/metrics"
+	"github.com/StatCan/namespace-controller/pkg/finance/propagation"
+	"github.com/StatCan/namespace-controller/pkg/finance/validation"
+	"github.com/StatCan/namespace-controller/pkg/finance/webhook"
 	"github.com/StatCan/namespace-controller/pkg/signals"
 	"github.com/spf13/cobra"
 	corev1 "k8s.io/api/core/v1"
-	meta "k8s.io/apimachinery/pkg/api/meta"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 )
 
+var financeConfigPath string
+var webhookAddr string
+var webhookCertFile string
+var webhookKeyFile string
+var allowlistFile string
+var allowlistConfigMap string
+var allowlistConfigMapKey string
+var allowlistURL string
+var allowlistRefreshInterval time.Duration
+var enforceWorkloadID bool
+
 var financeCmd = &cobra.Command{
 	Use:   "finance",
 	Short: "Manage namespace financial information",
 	Long: `
-Propagate labels from namespace to certain resources (Pods, PVCs) for finance tracking.
+Propagate labels from namespace to certain resources for finance tracking: Pods, PVCs,
+Deployments, StatefulSets, DaemonSets, Jobs, CronJobs, LoadBalancer Services and
+VolumeSnapshots. The resources, including custom resources, can be changed in the
+configuration file.
+
+By default, the finance.statcan.gc.ca/workload-id label is propagated. Other labels
+(e.g., cost centre, project or owner) are propagated by listing them in the
+configuration file provided with --config.
+
+With --webhook-addr, a mutating admission webhook also sets the labels on the
+resources as they are created, so that they are never unlabelled.
+
+The namespaces managed by the controller are selected with --namespace-selector,
+--include-namespaces and --exclude-namespaces. By default, every namespace is managed
+except those of the cluster control plane, which have the control-plane label.
+
+The resources requested in each namespace are exposed as Prometheus metrics, labelled
+with the workload-id of the namespace, on --metrics-addr (/metrics), alongside the
+metrics of the controller and its health checks (/healthz, /readyz).
+
+The workload-id of the namespaces is validated against an allowlist of the active billing
+codes when --workload-id-allowlist-file, --workload-id-allowlist-configmap or
+--workload-id-allowlist-url is provided. The allowlist is a CSV file of workload-ids with
+an optional expiry date, or a JSON array of {"workloadId": ..., "expires": ...} objects.
+Namespaces with an unknown or expired workload-id receive a Warning Event and a
+finance.statcan.gc.ca/workload-id-condition annotation, and their labels are not propagated
+with --enforce-workload-id.
+
+With --leader-elect, several replicas may be run for availability: only the replica
+holding the leader election Lease propagates the labels, while every replica serves
+the admission webhook.
+
+The report subcommand prints the resources requested by each workload-id for cost allocation.
 	`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Setup signals so we can shutdown cleanly
-		stopCh := signals.SetupSignalHandler()
+		ctx := signals.SetupSignalHandler()
 
 		// Create Kubernetes config
 		cfg, err := clientcmd.BuildConfigFromFlags(apiserver, kubeconfig)
@@ -40,170 +90,232 @@ Propagate labels from namespace to certain resources (Pods, PVCs) for finance tr
 			klog.Fatalf("Error building kubernetes clientset: %s", err.Error())
 		}
 
+		dynamicClient, err := dynamic.NewForConfig(cfg)
+		if err != nil {
+			klog.Fatalf("error building dynamic client: %v", err)
+		}
+
 		// Setup informers
 		kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Minute*5)
+		dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, time.Minute*5)
 
 		// Namespaces informer
 		namespaceInformer := kubeInformerFactory.Core().V1().Namespaces()
 		namespaceLister := namespaceInformer.Lister()
 
-		// Pod informers
-		podInformer := kubeInformerFactory.Core().V1().Pods()
-		podLister := podInformer.Lister()
+		// Select the namespaces whose resources are labelled
+		filter := newNamespaceFilter()
+		skip := func(namespace *corev1.Namespace) bool {
+			return !filter.Matches(namespace)
+		}
 
-		// PV informers
-		pvcInformer := kubeInformerFactory.Core().V1().PersistentVolumeClaims()
-		pvcLister := pvcInformer.Lister()
+		// Load the controller configuration
+		financeConfig, err := loadFinanceConfig(kubeClient)
+		if err != nil {
+			klog.Fatalf("error loading configuration: %v", err)
+		}
 
-		// Setup controller
-		controller := namespaces.NewController(
-			namespaceInformer,
-			func(namespace *corev1.Namespace) error {
-				// Skip 'control-plane' namespaces
-				if _, ok := namespace.ObjectMeta.Labels["control-plane"]; ok {
-					klog.Infof("skipping namespace <%v> as it is a cluster control plane namespace", namespace.Name)
-					return nil
-				}
+		// Report the changes instead of applying them in dry-run mode
+		changes := newChangeRecorder()
 
-				// Propagate 'workload-id' to pod resources
-				if _, ok := namespace.ObjectMeta.Labels["finance.statcan.gc.ca/workload-id"]; ok {
-					klog.Infof("propagating namespace <%v> workload-id labels to pod resources", namespace.Name)
-					namespacePods, err := podLister.Pods(namespace.Name).List(labels.Everything())
-					if err != nil {
-						klog.Infof("failed to list pods under namespace %s", namespace.Name)
-						return nil
-					}
+		// Setup the propagation of the labels, with informers for each kind of resource
+		propagator := propagation.NewPropagator(financeConfig, dynamicClient, dynamicInformerFactory, changes)
 
-					for _, pod := range namespacePods {
-						existingLabels := pod.Labels
-						if existingLabels["finance.statcan.gc.ca/workload-id"] != namespace.ObjectMeta.Labels["workload-id"] {
-							existingLabels["finance.statcan.gc.ca/workload-id"] = namespace.ObjectMeta.Labels["workload-id"]
-							pod.SetLabels(existingLabels)
-							_, err = kubeClient.CoreV1().Pods(pod.Namespace).Update(context.Background(), pod, metav1.UpdateOptions{})
-							if err != nil {
-								return err
-							}
-						}
-					}
-				}
+		// Setup event recording, to report invalid workload-ids to the namespaces
+		eventBroadcaster := record.NewBroadcaster()
+		eventBroadcaster.StartLogging(klog.Infof)
+		eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+		recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "namespace-controller-finance"})
+
+		// Setup the validation of the workload-ids, when an allowlist is provided
+		var controller *namespaces.Controller
+		var validator *validation.Validator
+		allowlistSource, err := newAllowlistSource(kubeClient)
+		if err != nil {
+			klog.Fatalf("error setting up workload-id allowlist: %v", err)
+		}
+		if allowlistSource != nil {
+			validator = validation.NewValidator(allowlistSource, kubeClient, recorder, changes, func() {
+				// Re-validate every namespace when the allowlist changes
+				controller.EnqueueAllNamespaces()
+			})
+			if err := validator.Refresh(ctx); err != nil {
+				klog.Fatalf("error loading workload-id allowlist: %v", err)
+			}
+		} else if enforceWorkloadID {
+			klog.Fatalf("--enforce-workload-id requires a workload-id allowlist")
+		}
+
+		// Setup the metrics of the controller, before its workqueue is created.
+		// The resources requested by each workload are computed from the
+		// objects cached by the propagator.
+		registry := newMetricsRegistry()
+		podLister := propagator.Lister(financeconfig.KindPod)
+		pvcLister := propagator.Lister(financeconfig.KindPersistentVolumeClaim)
+		if metricsAddr != "" && (podLister == nil || pvcLister == nil) {
+			klog.Warningf("labels are not propagated to pods or persistent volume claims; their metrics will not be exposed")
+		}
+		registry.MustRegister(financemetrics.NewCollector(namespaceLister, podLister, pvcLister, skip))
 
-				// Propagate 'workload-id' to pvc resources
-				if _, ok := namespace.ObjectMeta.Labels["finance.statcan.gc.ca/workload-id"]; ok {
-					klog.Infof("propagating namespace <%v> workload-id labels to pvc resources", namespace.Name)
-					namespacePvcs, err := pvcLister.List(labels.Everything())
+		// Setup controller
+		builder := namespaces.NewBuilder(
+			"finance",
+			namespaceInformer,
+			func(ctx context.Context, namespace *corev1.Namespace) error {
+				// Validate the workload-id of the namespace
+				if validator != nil {
+					valid, err := validator.Sync(ctx, namespace)
 					if err != nil {
-						klog.Infof("failed to list pvc under namespace %s", namespace.Name)
-						return nil
+						return err
 					}
-
-					for _, pvc := range namespacePvcs {
-						existingLabels := pvc.Labels
-						if existingLabels["finance.statcan.gc.ca/workload-id"] != namespace.ObjectMeta.Labels["workload-id"] {
-							existingLabels["finance.statcan.gc.ca/workload-id"] = namespace.ObjectMeta.Labels["workload-id"]
-							pvc.SetLabels(existingLabels)
-							_, err = kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(context.Background(), pvc, metav1.UpdateOptions{})
-							if err != nil {
-								return err
-							}
-						}
+					if !valid && enforceWorkloadID {
+						klog.Infof("skipping namespace <%v> as its workload-id is invalid", namespace.Name)
+						return nil
 					}
 				}
 
-				return nil
+				// Propagate the namespace labels to the resources of the namespace
+				return propagator.Sync(ctx, namespace)
 			},
-		)
-
-		// Setup callback handlers when new objects are created
-		//   Upon an Add or Update event, we will trigger a resync
-		//   of the relevant namespace in order to ensure the labels
-		//   are correctly applied.
-		metaAccessor := meta.NewAccessor()
-		eventHandlers := cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				new := obj.(runtime.Object)
-				addTypeInformationToObject(new)
-
-				// Queue the namespace for further processing
-				objectName, _ := metaAccessor.Name(new)
-				namespaceName, err := metaAccessor.Namespace(new)
-				if err != nil {
-					klog.Errorf("failed accessing namespace name for %s/%s: %v", new.GetObjectKind().GroupVersionKind().Kind, objectName, err)
-					return
-				}
+		).WithTimeout(reconcileTimeout).WithFilter(filter)
 
-				namespace, err := namespaceLister.Get(namespaceName)
-				if err != nil {
-					klog.Errorf("failed loading namespace <%s> for %s/%s: %v", namespaceName, new.GetObjectKind().GroupVersionKind().Kind, objectName, err)
-					return
-				}
+		// Sync the namespace when the resources it contains change, so that
+		// the labels are applied to the new resources
+		for _, informer := range propagator.Informers() {
+			builder.Watches(informer, namespaces.ByNamespace)
+		}
 
-				klog.Infof("queuing namespace <%s> for processing due to update to %s/%s", namespace.Name, new.GetObjectKind().GroupVersionKind().Kind, objectName)
-				controller.EnqueueNamespace(namespace)
-			},
-			UpdateFunc: func(newObj, oldObj interface{}) {
-				new := newObj.(runtime.Object)
-				old := oldObj.(runtime.Object)
-				addTypeInformationToObject(new)
-				addTypeInformationToObject(old)
-
-				// Load resource versions of the new and old object.
-				// If they are the same, then the objects have not changed
-				// and we don't need to continue processing it. This happens
-				// when the informer is re-synchronized against the API server.
-				newResourceVersion, err := metaAccessor.ResourceVersion(new)
-				if err != nil {
-					klog.Errorf("failed loading resource version: %v", err)
-					return
-				}
+		forgetDeletedNamespaces(builder, changes)
+		controller = builder.Build()
 
-				oldResourceVersion, err := metaAccessor.ResourceVersion(old)
-				if err != nil {
-					klog.Errorf("failed loading resource version: %v", err)
-					return
-				}
+		cacheSyncs := []cache.InformerSynced{namespaceInformer.Informer().HasSynced}
+		for _, informer := range propagator.Informers() {
+			cacheSyncs = append(cacheSyncs, informer.HasSynced)
+		}
 
-				if newResourceVersion == oldResourceVersion {
-					return
-				}
+		// Serve the health checks and metrics, when enabled
+		srv := startServer(ctx, registry)
 
-				// Queue the namespace for further processing
-				objectName, _ := metaAccessor.Name(new)
-				namespaceName, err := metaAccessor.Namespace(new)
-				if err != nil {
-					klog.Errorf("failed accessing namespace name for %s/%s: %v", new.GetObjectKind().GroupVersionKind().Kind, objectName, err)
-					return
+		// Start informers
+		kubeInformerFactory.Start(ctx.Done())
+		dynamicInformerFactory.Start(ctx.Done())
+
+		// Wait for caches
+		klog.Info("Waiting for informer caches to sync")
+		if ok := cache.WaitForCacheSync(ctx.Done(), cacheSyncs...); !ok {
+			klog.Fatalf("failed to wait for caches to sync")
+		}
+		srv.SetReady()
+
+		// Label resources as they are created, when the webhook is enabled.
+		// The controller continues to label resources created while the
+		// webhook was unavailable.
+		if webhookAddr != "" {
+			skipWebhook := skip
+			if enforceWorkloadID {
+				skipWebhook = func(namespace *corev1.Namespace) bool {
+					return skip(namespace) || validation.IsInvalid(namespace)
 				}
+			}
 
-				namespace, err := namespaceLister.Get(namespaceName)
-				if err != nil {
-					klog.Errorf("failed loading namespace <%s> for %s/%s: %v", namespaceName, new.GetObjectKind().GroupVersionKind().Kind, objectName, err)
-					return
+			handler := webhook.NewHandler(financeConfig, namespaceLister, skipWebhook, dryRun)
+			go func() {
+				if err := webhook.Serve(ctx, webhookAddr, webhookCertFile, webhookKeyFile, handler); err != nil {
+					klog.Fatalf("error serving admission webhook: %v", err)
 				}
+			}()
+		}
 
-				klog.Infof("queuing namespace <%s> for processing due to update to %s/%s", namespace.Name, new.GetObjectKind().GroupVersionKind().Kind, objectName)
-				controller.EnqueueNamespace(namespace)
-			},
+		// Periodically reload the workload-id allowlist
+		if validator != nil {
+			go validator.Run(ctx, allowlistRefreshInterval)
 		}
 
-		podInformer.Informer().AddEventHandler(eventHandlers)
-		pvcInformer.Informer().AddEventHandler(eventHandlers)
+		// Periodically report the changes of the dry-run
+		go reportChanges(ctx, changes, dryRunSummaryInterval)
 
-		// Start informers
-		kubeInformerFactory.Start(stopCh)
+		// Run the controller, on the leader only when leader election is
+		// enabled. The standby replicas keep their caches warm to take over.
+		runAsLeader(ctx, kubeClient, func(ctx context.Context) {
+			if err := controller.Run(ctx); err != nil {
+				klog.Fatalf("error running controller: %v", err)
+			}
+		})
 
-		// Wait for caches
-		klog.Info("Waiting for informer caches to sync")
-		if ok := cache.WaitForCacheSync(stopCh, podInformer.Informer().HasSynced, pvcInformer.Informer().HasSynced); !ok {
-			klog.Fatalf("failed to wait for caches to sync")
+		if changes != nil {
+			fmt.Print(changes.Summary())
+		}
+	},
+}
+
+// loadFinanceConfig loads the configuration file provided with --config, or
+// the default configuration, keeping the resources installed in the cluster.
+func loadFinanceConfig(kubeClient kubernetes.Interface) (*financeconfig.Config, error) {
+	financeConfig := financeconfig.Default()
+	if financeConfigPath != "" {
+		var err error
+		financeConfig, err = financeconfig.Load(financeConfigPath)
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	// Skip the resources which are not installed in the cluster
+	financeConfig.Resources = propagation.AvailableResources(kubeClient.Discovery(), financeConfig.Resources)
+
+	return financeConfig, nil
+}
 
-		// Run the controller
-		if err = controller.Run(2, stopCh); err != nil {
-			klog.Fatalf("error running controller: %v", err)
+// newAllowlistSource returns the source of the workload-id allowlist selected
+// by the flags, or nil when the workload-ids are not validated.
+func newAllowlistSource(kubeClient kubernetes.Interface) (validation.Source, error) {
+	sources := []validation.Source{}
+	if allowlistFile != "" {
+		sources = append(sources, &validation.FileSource{Path: allowlistFile})
+	}
+	if allowlistConfigMap != "" {
+		configMapNamespace, configMapName, err := cache.SplitMetaNamespaceKey(allowlistConfigMap)
+		if err != nil || configMapNamespace == "" {
+			return nil, fmt.Errorf("invalid ConfigMap %q: expected <namespace>/<name>", allowlistConfigMap)
 		}
-	},
+		sources = append(sources, &validation.ConfigMapSource{
+			Client:    kubeClient,
+			Namespace: configMapNamespace,
+			Name:      configMapName,
+			Key:       allowlistConfigMapKey,
+		})
+	}
+	if allowlistURL != "" {
+		sources = append(sources, &validation.HTTPSource{URL: allowlistURL})
+	}
+
+	switch len(sources) {
+	case 0:
+		return nil, nil
+	case 1:
+		return sources[0], nil
+	default:
+		return nil, fmt.Errorf("only one of --workload-id-allowlist-file, --workload-id-allowlist-configmap and --workload-id-allowlist-url may be provided")
+	}
 }
 
 func init() {
 	rootCmd.AddCommand(financeCmd)
+
+	financeCmd.PersistentFlags().StringVar(&financeConfigPath, "config", "", "Path to the configuration file listing the namespace labels to propagate")
+	financeCmd.Flags().StringVar(&webhookAddr, "webhook-addr", "", "Address serving the mutating admission webhook labelling resources on creation (e.g., :8443); disabled when empty")
+	financeCmd.Flags().StringVar(&webhookCertFile, "webhook-cert-file", "/etc/webhook/certs/tls.crt", "Path to the TLS certificate of the admission webhook")
+	financeCmd.Flags().StringVar(&webhookKeyFile, "webhook-key-file", "/etc/webhook/certs/tls.key", "Path to the TLS private key of the admission webhook")
+	financeCmd.Flags().DurationVar(&reconcileTimeout, "reconcile-timeout", time.Minute, "Time after which the API requests made to sync the labels of a namespace are cancelled and the namespace is retried; disabled when 0")
+	financeCmd.Flags().StringVar(&metricsAddr, "metrics-addr", ":8080", "Address serving the health checks and the Prometheus metrics of the controller and of the resources requested by each workload-id; disabled when empty")
+	financeCmd.Flags().StringVar(&allowlistFile, "workload-id-allowlist-file", "", "Path to a CSV or JSON file listing the valid workload-ids")
+	financeCmd.Flags().StringVar(&allowlistConfigMap, "workload-id-allowlist-configmap", "", "ConfigMap listing the valid workload-ids, as <namespace>/<name>")
+	financeCmd.Flags().StringVar(&allowlistConfigMapKey, "workload-id-allowlist-configmap-key", "workload-ids.csv", "Key of the workload-id allowlist ConfigMap, parsed as JSON when it ends in .json and as CSV otherwise")
+	financeCmd.Flags().StringVar(&allowlistURL, "workload-id-allowlist-url", "", "URL returning the valid workload-ids as a JSON array")
+	financeCmd.Flags().DurationVar(&allowlistRefreshInterval, "workload-id-allowlist-refresh-interval", time.Minute*5, "Interval at which the workload-id allowlist is reloaded")
+	financeCmd.Flags().BoolVar(&enforceWorkloadID, "enforce-workload-id", false, "Do not propagate the labels of namespaces with an unknown or expired workload-id")
+	addLeaderElectionFlags(financeCmd, "namespace-controller-finance")
+	addNamespaceSelectionFlags(financeCmd)
+	financeCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report the changes to the labels of the resources as diffs, using server-side dry-run, without persisting them")
+	financeCmd.Flags().DurationVar(&dryRunSummaryInterval, "dry-run-summary-interval", time.Minute, "Interval at which the number of resources which would change is printed in dry-run mode")
 }
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/StatCan/namespace-controller/pkg/controllers/namespaces"
+	financeconfig "github.com/StatCan/namespace-controller/pkg/finance/config"
+	"github.com/StatCan/namespace-controller/pkg/finance/propagation"
+	"github.com/StatCan/namespace-controller/pkg/finance/report"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog"
+)
+
+var reportOutput string
+
+var financeReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Report the resources requested by each workload for cost allocation.",
+	Long: `Report the resources requested by each workload for cost allocation.
+
+The namespaces are grouped by their finance.statcan.gc.ca/workload-id label. For each
+workload-id, the report lists the namespaces, the number of pods which are not terminated,
+the CPU, memory and GPU (nvidia.com/gpu) requested by the pods, and the storage requested
+by the PersistentVolumeClaims for each storage class. Namespaces without the label are
+reported under an empty workload-id. As with the finance command, only the namespaces
+selected by --namespace-selector, --include-namespaces and --exclude-namespaces are
+reported; the control plane namespaces are skipped by default.
+
+The pods and PersistentVolumeClaims are listed through the same informers as the finance
+command, so they are only reported when they are among the resources of --config.
+
+The report is printed as CSV (--output csv), with CPUs in cores and memory and storage
+in bytes, or as JSON (--output json), with Kubernetes quantities.
+	`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := writeFinanceReport(cmd.OutOrStdout()); err != nil {
+			klog.Fatalf("error generating report: %v", err)
+		}
+	},
+}
+
+// writeFinanceReport lists the namespaces, pods and PersistentVolumeClaims
+// through the informers of the finance command and writes the report to out.
+// The pods and claims are only reported when they are configured as
+// resources the labels are propagated to.
+func writeFinanceReport(out io.Writer) error {
+	if reportOutput != "csv" && reportOutput != "json" {
+		return fmt.Errorf("unsupported output format %q: expected csv or json", reportOutput)
+	}
+
+	filter, err := namespaces.NewFilter(namespaceSelector, includeNamespaces, excludeNamespaces)
+	if err != nil {
+		return fmt.Errorf("error selecting namespaces: %v", err)
+	}
+
+	// Create Kubernetes config
+	cfg, err := clientcmd.BuildConfigFromFlags(apiserver, kubeconfig)
+	if err != nil {
+		return fmt.Errorf("error building kubeconfig: %v", err)
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("error building kubernetes clientset: %v", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("error building dynamic client: %v", err)
+	}
+
+	financeConfig, err := loadFinanceConfig(kubeClient)
+	if err != nil {
+		return fmt.Errorf("error loading configuration: %v", err)
+	}
+
+	// Setup the informers of the finance command, registering them before
+	// starting the factories
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, 0)
+	dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 0)
+	namespaceInformer := kubeInformerFactory.Core().V1().Namespaces()
+	propagator := propagation.NewPropagator(financeConfig, dynamicClient, dynamicInformerFactory, nil)
+
+	podLister := propagator.Lister(financeconfig.KindPod)
+	pvcLister := propagator.Lister(financeconfig.KindPersistentVolumeClaim)
+	if podLister == nil || pvcLister == nil {
+		klog.Warningf("labels are not propagated to pods or persistent volume claims; their requests will not be reported")
+	}
+
+	cacheSyncs := []cache.InformerSynced{namespaceInformer.Informer().HasSynced}
+	for _, informer := range propagator.Informers() {
+		cacheSyncs = append(cacheSyncs, informer.HasSynced)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	kubeInformerFactory.Start(stopCh)
+	dynamicInformerFactory.Start(stopCh)
+
+	klog.Info("Waiting for informer caches to sync")
+	if ok := cache.WaitForCacheSync(stopCh, cacheSyncs...); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	allNamespaces, err := namespaceInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %v", err)
+	}
+
+	// Skip the namespaces which are not selected, as the finance command does
+	selected := []*corev1.Namespace{}
+	for _, namespace := range allNamespaces {
+		if filter.Matches(namespace) {
+			selected = append(selected, namespace)
+		}
+	}
+
+	r, err := report.FromListers(selected, podLister, pvcLister)
+	if err != nil {
+		return err
+	}
+
+	if reportOutput == "json" {
+		return r.WriteJSON(out)
+	}
+
+	return r.WriteCSV(out)
+}
+
+func init() {
+	financeReportCmd.Flags().StringVarP(&reportOutput, "output", "o", "csv", "Format of the report: csv or json")
+	addNamespaceSelectionFlags(financeReportCmd)
+
+	financeCmd.AddCommand(financeReportCmd)
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog"
+)
+
+// serviceAccountNamespaceFile holds the namespace of the pod running the controller.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+var leaderElect bool
+var leaderElectionLeaseName string
+var leaderElectionNamespace string
+var leaderElectionLeaseDuration time.Duration
+var leaderElectionRenewDeadline time.Duration
+var leaderElectionRetryPeriod time.Duration
+
+// runAsLeader calls run once this replica holds the leader election lease,
+// when leader election is enabled, and immediately otherwise. The context
+// passed to run is cancelled when ctx is cancelled. The process exits when
+// the lease is lost, so that the controllers never run on two replicas at once.
+func runAsLeader(ctx context.Context, kubeClient kubernetes.Interface, run func(ctx context.Context)) {
+	if !leaderElect {
+		run(ctx)
+		return
+	}
+
+	lock, err := newLeaderElectionLock(kubeClient)
+	if err != nil {
+		klog.Fatalf("error setting up leader election: %v", err)
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaderElectionLeaseDuration,
+		RenewDeadline:   leaderElectionRenewDeadline,
+		RetryPeriod:     leaderElectionRetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            leaderElectionLeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.Infof("acquired the leader election lease %s/%s", lock.LeaseMeta.Namespace, lock.LeaseMeta.Name)
+				run(ctx)
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() != nil {
+					klog.Infof("released the leader election lease %s/%s", lock.LeaseMeta.Namespace, lock.LeaseMeta.Name)
+					return
+				}
+				klog.Fatalf("lost the leader election lease %s/%s", lock.LeaseMeta.Namespace, lock.LeaseMeta.Name)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != lock.Identity() {
+					klog.Infof("the controller is run by the leader %s", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		klog.Fatalf("error setting up leader election: %v", err)
+	}
+
+	klog.Infof("waiting to acquire the leader election lease %s/%s as %s", lock.LeaseMeta.Namespace, lock.LeaseMeta.Name, lock.Identity())
+	elector.Run(ctx)
+}
+
+// newLeaderElectionLock returns the Lease held by the leader, identified by
+// the hostname of the replica (the name of its pod).
+func newLeaderElectionLock(kubeClient kubernetes.Interface) (*resourcelock.LeaseLock, error) {
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hostname: %w", err)
+	}
+
+	namespace := leaderElectionNamespace
+	if namespace == "" {
+		data, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("--leader-election-namespace is required outside of a cluster: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	return &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      leaderElectionLeaseName,
+		},
+		Client: kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}, nil
+}
+
+// addLeaderElectionFlags registers the leader election flags of a command,
+// whose lease is named leaseName by default.
+func addLeaderElectionFlags(cmd *cobra.Command, leaseName string) {
+	cmd.Flags().BoolVar(&leaderElect, "leader-elect", false, "Run the controller on the replica holding a Lease only, so that several replicas can be run for availability")
+	cmd.Flags().StringVar(&leaderElectionLeaseName, "leader-election-lease-name", leaseName, "Name of the Lease held by the leader")
+	cmd.Flags().StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "Namespace of the Lease held by the leader (defaults to the namespace of the pod)")
+	cmd.Flags().DurationVar(&leaderElectionLeaseDuration, "leader-election-lease-duration", time.Second*15, "Time the standby replicas wait before taking over a Lease which was not renewed")
+	cmd.Flags().DurationVar(&leaderElectionRenewDeadline, "leader-election-renew-deadline", time.Second*10, "Time the leader retries renewing the Lease before stopping")
+	cmd.Flags().DurationVar(&leaderElectionRetryPeriod, "leader-election-retry-period", time.Second*2, "Interval at which the replicas try to acquire or renew the Lease")
+}
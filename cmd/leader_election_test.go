@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRunAsLeader(t *testing.T) {
+	leaderElect = true
+	leaderElectionLeaseName = "test"
+	leaderElectionNamespace = "alpha"
+	leaderElectionLeaseDuration = time.Second * 15
+	leaderElectionRenewDeadline = time.Second * 10
+	leaderElectionRetryPeriod = time.Second * 2
+	defer func() { leaderElect = false }()
+
+	kubeClient := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		runAsLeader(ctx, kubeClient, func(leaderCtx context.Context) {
+			lease, err := kubeClient.CoordinationV1().Leases("alpha").Get(context.Background(), "test", metav1.GetOptions{})
+			if err != nil {
+				t.Errorf("expected the lease to be acquired: %v", err)
+			} else if holder := lease.Spec.HolderIdentity; holder == nil || *holder == "" {
+				t.Errorf("expected the lease to have a holder")
+			}
+
+			cancel()
+			<-leaderCtx.Done()
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 10):
+		t.Fatalf("expected the controller to run on the leader and stop with the command")
+	}
+
+	// The lease is released on shutdown, for a standby to take over
+	lease, err := kubeClient.CoordinationV1().Leases("alpha").Get(context.Background(), "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get lease: %v", err)
+	}
+	if holder := lease.Spec.HolderIdentity; holder != nil && *holder != "" {
+		t.Errorf("expected the lease to be released, held by %s", *holder)
+	}
+}
+
+func TestRunAsLeaderDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ran := false
+	runAsLeader(ctx, fake.NewSimpleClientset(), func(runCtx context.Context) {
+		ran = runCtx == ctx
+	})
+	if !ran {
+		t.Errorf("expected the controller to run immediately with the context of the command")
+	}
+}
@@ -3,35 +3,78 @@ package cmd
 import (
 	"context"
 	"fmt"
-	"net"
-	"reflect"
 	"strconv"
 	"time"
 
+	networkv1alpha1 "github.com/StatCan/namespace-controller/pkg/apis/network/v1alpha1"
 	"github.com/StatCan/namespace-controller/pkg/controllers/namespaces"
+	"github.com/StatCan/namespace-controller/pkg/network/config"
+	"github.com/StatCan/namespace-controller/pkg/network/templates"
 	"github.com/StatCan/namespace-controller/pkg/signals"
 	"github.com/spf13/cobra"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 )
 
+var networkConfigPath string
+var policyBackendName string
+var calicoGlobalDefaultDeny bool
+var apiServerDebounce time.Duration
+var enableNetworkProfiles bool
+var policyTemplatesDir string
+var policyTemplatesConfigMap string
+var policyTemplatesPollInterval time.Duration
+
 var networkCmd = &cobra.Command{
 	Use:   "network",
 	Short: "Configure network resources for namespaces.",
 	Long: `Configure network resources for namespaces.
 * Network policies
+
+The namespaces managed by the controller are selected with --namespace-selector,
+--include-namespaces and --exclude-namespaces. By default, every namespace is managed
+except those of the cluster control plane, which have the control-plane label.
+
+Network policies are rendered from a set of YAML templates. The built-in
+templates are used unless --policy-templates-dir or --policy-templates-configmap
+is provided, in which case the templates are reloaded when the source changes.
+
+With --enable-network-profiles, namespaces select a NetworkProfile using the
+network.statcan.gc.ca/profile label. Namespaces without the label, or selecting
+a profile which does not exist, continue to use the network.statcan.gc.ca/allow-same-ns
+and allow-ingress-controller labels.
+
+The rendered policies are applied as networking.k8s.io/v1 NetworkPolicies, or
+converted to Cilium or Calico policies with --policy-backend. With the Calico
+backend, --calico-global-default-deny also maintains a GlobalNetworkPolicy denying
+the traffic of every namespace matching --namespace-selector, including the
+namespaces which were not synced yet.
+
+With --leader-elect, several replicas may be run for availability: only the replica
+holding the leader election Lease updates the policies.
+
+Health checks (/healthz, /readyz) and Prometheus metrics of the controller (/metrics)
+are served on --metrics-addr.
+
+The policies of a namespace can be previewed offline with the render subcommand.
 	`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Setup signals so we can shutdown cleanly
-		stopCh := signals.SetupSignalHandler()
+		ctx := signals.SetupSignalHandler()
 
 		// Create Kubernetes config
 		cfg, err := clientcmd.BuildConfigFromFlags(apiserver, kubeconfig)
@@ -47,386 +90,378 @@ var networkCmd = &cobra.Command{
 		// Setup informers
 		kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Minute*5)
 
-		networkPolicyInformer := kubeInformerFactory.Networking().V1().NetworkPolicies()
-		networkPolicyLister := networkPolicyInformer.Lister()
-
 		// Listen for endpoints for the `kubernetes` service
 		kubeDefaultNsInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, time.Minute*5, kubeinformers.WithNamespace("default"))
 
 		defaultNsEndpointsInformer := kubeDefaultNsInformerFactory.Core().V1().Endpoints()
 		defaultNsEndpointsLister := defaultNsEndpointsInformer.Lister()
 
+		dynamicClient, err := dynamic.NewForConfig(cfg)
+		if err != nil {
+			klog.Fatalf("error building dynamic client: %v", err)
+		}
+
+		// Listen for the endpoint slices of the `kubernetes` service, when supported by the cluster
+		var defaultNsEndpointSliceInformer kubeinformers.GenericInformer
+		var defaultNsEndpointSliceLister cache.GenericLister
+		var dynamicDefaultNsInformerFactory dynamicinformer.DynamicSharedInformerFactory
+		if endpointSlicesResource, err := servedEndpointSlicesResource(kubeClient.Discovery()); err != nil {
+			klog.Warningf("endpoint slices are unavailable (%v); using endpoints to discover the Kubernetes API server", err)
+		} else {
+			dynamicDefaultNsInformerFactory = dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, time.Minute*5, "default", func(opts *metav1.ListOptions) {
+				opts.LabelSelector = kubernetesServiceSelector.String()
+			})
+			defaultNsEndpointSliceInformer = dynamicDefaultNsInformerFactory.ForResource(endpointSlicesResource)
+			defaultNsEndpointSliceLister = defaultNsEndpointSliceInformer.Lister()
+		}
+
+		dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, time.Minute*5)
+
+		// Listen for network profiles
+		var networkProfileInformer kubeinformers.GenericInformer
+		var networkProfileLister cache.GenericLister
+		if enableNetworkProfiles {
+			networkProfileInformer = dynamicInformerFactory.ForResource(networkv1alpha1.NetworkProfilesResource)
+			networkProfileLister = networkProfileInformer.Lister()
+		}
+
+		// Setup event recording, to report corrections to the namespaces
+		eventBroadcaster := record.NewBroadcaster()
+		eventBroadcaster.StartLogging(klog.Infof)
+		eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+		recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "namespace-controller-network"})
+		tracker := newDriftTracker()
+		changes := newChangeRecorder()
+
+		// Setup the backend applying the policies
+		if calicoGlobalDefaultDeny && policyBackendName != policyBackendCalico {
+			klog.Fatalf("--calico-global-default-deny requires --policy-backend=%s", policyBackendCalico)
+		}
+		var globalDefaultDeny *unstructured.Unstructured
+		if calicoGlobalDefaultDeny {
+			// Calico selectors cannot match the names of the namespaces
+			if len(includeNamespaces) > 0 || len(excludeNamespaces) > 0 {
+				klog.Fatalf("--calico-global-default-deny cannot be combined with --include-namespaces or --exclude-namespaces")
+			}
+			globalDefaultDeny, err = newCalicoGlobalDefaultDeny(namespaceSelector)
+			if err != nil {
+				klog.Fatalf("error setting up the global default deny policy: %v", err)
+			}
+		}
+		backend, err := newPolicyBackend(policyBackendName, kubeClient, kubeInformerFactory, dynamicClient, dynamicInformerFactory, tracker, recorder, changes)
+		if err != nil {
+			klog.Fatalf("error setting up policy backend: %v", err)
+		}
+
+		// Load the controller configuration
+		networkConfig := config.Default()
+		if networkConfigPath != "" {
+			networkConfig, err = config.Load(networkConfigPath)
+			if err != nil {
+				klog.Fatalf("error loading configuration: %v", err)
+			}
+		}
+
+		// Load the policy templates
+		templateSet := templates.Default()
+		if policyTemplatesDir != "" {
+			templateSet, err = templates.LoadDirectory(policyTemplatesDir)
+			if err != nil {
+				klog.Fatalf("error loading policy templates: %v", err)
+			}
+		}
+
+		// Report missing network profiles to the namespaces, except in dry-run mode
+		var profileRecorder record.EventRecorder
+		if changes == nil {
+			profileRecorder = recorder
+		}
+
 		// Setup controller
-		controller := namespaces.NewController(
-			kubeInformerFactory.Core().V1().Namespaces(),
-			func(namespace *corev1.Namespace) error {
-				// Skip 'control-plane' namespaces
-				if _, ok := namespace.ObjectMeta.Labels["control-plane"]; ok {
-					klog.Infof("skipping namespace <%v> as it is a cluster control plane namespace", namespace.Name)
-					return nil
-				}
+		var controller *namespaces.Controller
+		templateStore := templates.NewStore(templateSet, func(*templates.Set) {
+			// Re-render the policies of every namespace when the templates change
+			controller.EnqueueAllNamespaces()
+		})
 
+		// Setup the metrics of the controller, before its workqueue is created
+		registry := newMetricsRegistry()
+
+		builder := namespaces.NewBuilder(
+			"network",
+			kubeInformerFactory.Core().V1().Namespaces(),
+			func(ctx context.Context, namespace *corev1.Namespace) error {
 				// Create default network policies to prevent ingress traffic
-				apiServerEndpoints, err := defaultNsEndpointsLister.Endpoints("default").Get("kubernetes")
+				apiServer, err := apiServerAddresses(defaultNsEndpointSliceLister, defaultNsEndpointsLister)
 				if err != nil {
-					return fmt.Errorf("failed to list endpoints of Kubernetes API server: %v", err)
+					return err
 				}
 
-				policies := generateNetworkPolicies(namespace, apiServerEndpoints)
-
-				for _, policy := range policies {
-					currentPolicy, err := networkPolicyLister.NetworkPolicies(policy.Namespace).Get(policy.Name)
-					if errors.IsNotFound(err) {
-						klog.Infof("creating network policy %s/%s", policy.Namespace, policy.Name)
-						currentPolicy, err = kubeClient.NetworkingV1().NetworkPolicies(policy.Namespace).Create(context.Background(), policy, metav1.CreateOptions{})
+				// Load the network profile selected by the namespace
+				var profile *networkv1alpha1.NetworkProfile
+				if profileName, ok := namespace.ObjectMeta.Labels[networkv1alpha1.ProfileLabel]; ok {
+					if !enableNetworkProfiles {
+						klog.Warningf("namespace <%s> selects network profile %q but network profiles are not enabled; using labels", namespace.Name, profileName)
+					} else {
+						profile, err = selectNetworkProfile(networkProfileLister, namespace, profileName, profileRecorder)
 						if err != nil {
 							return err
 						}
 					}
+				}
 
-					if !reflect.DeepEqual(policy.Spec, currentPolicy.Spec) {
-						klog.Infof("updating network policy %s/%s", policy.Namespace, policy.Name)
-						currentPolicy.Spec = policy.Spec
-
-						_, err = kubeClient.NetworkingV1().NetworkPolicies(policy.Namespace).Update(context.Background(), currentPolicy, metav1.UpdateOptions{})
-						if err != nil {
-							return err
-						}
-					}
+				policies, err := generateNetworkPolicies(templateStore.Get(), networkConfig, namespace, profile, apiServer)
+				if err != nil {
+					return fmt.Errorf("failed to generate network policies: %v", err)
 				}
 
-				return nil
+				return backend.Sync(ctx, namespace, policies)
 			},
-		)
+		).WithTimeout(reconcileTimeout).WithFilter(newNamespaceFilter())
+
+		forgetDeletedNamespaces(builder, changes)
+		controller = builder.Build()
+
+		namespaceLister := kubeInformerFactory.Core().V1().Namespaces().Lister()
+
+		// Revert changes made to the managed network policies
+		backend.Informer().AddEventHandler(networkPolicyEventHandlers(controller, namespaceLister, tracker))
+
+		// Re-sync every namespace when the addresses of the Kubernetes API server change
+		// (through either its endpoints or endpoint slices),
+		// waiting for changes to settle so that a rolling upgrade of the control plane
+		// results in a single wave of updates.
+		apiServerDebouncer := newDebouncer(apiServerDebounce, func() {
+			klog.Info("queuing all namespaces for processing due to update to the Kubernetes API server endpoints")
+			controller.EnqueueAllNamespaces()
+		})
+
+		defaultNsEndpointsInformer.Informer().AddEventHandler(apiServerEndpointsEventHandlers(apiServerDebouncer))
+		if defaultNsEndpointSliceInformer != nil {
+			defaultNsEndpointSliceInformer.Informer().AddEventHandler(apiServerEndpointSliceEventHandlers(apiServerDebouncer))
+		}
+		if enableNetworkProfiles {
+			networkProfileInformer.Informer().AddEventHandler(networkProfileEventHandlers(controller, namespaceLister))
+		}
+
+		// Watch the source of the policy templates for changes
+		cacheSyncs := []cache.InformerSynced{backend.Informer().HasSynced, defaultNsEndpointsInformer.Informer().HasSynced}
+		if defaultNsEndpointSliceInformer != nil {
+			cacheSyncs = append(cacheSyncs, defaultNsEndpointSliceInformer.Informer().HasSynced)
+		}
+		if enableNetworkProfiles {
+			cacheSyncs = append(cacheSyncs, networkProfileInformer.Informer().HasSynced)
+		}
+
+		if policyTemplatesConfigMap != "" {
+			configMapNamespace, configMapName, err := cache.SplitMetaNamespaceKey(policyTemplatesConfigMap)
+			if err != nil {
+				klog.Fatalf("invalid policy templates ConfigMap %q: %v", policyTemplatesConfigMap, err)
+			}
+			if configMapNamespace == "" {
+				klog.Fatalf("invalid policy templates ConfigMap %q: expected <namespace>/<name>", policyTemplatesConfigMap)
+			}
+
+			configMapInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, time.Minute*5,
+				kubeinformers.WithNamespace(configMapNamespace),
+				kubeinformers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+					opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", configMapName).String()
+				}))
+
+			configMapInformer := configMapInformerFactory.Core().V1().ConfigMaps()
+			loadConfigMap := func(obj interface{}) {
+				configMap, ok := obj.(*corev1.ConfigMap)
+				if !ok {
+					return
+				}
+
+				set, err := templates.Parse(configMap.Data)
+				if err != nil {
+					klog.Errorf("failed to load policy templates from ConfigMap %s/%s: %v", configMap.Namespace, configMap.Name, err)
+					return
+				}
+
+				templateStore.Update(set)
+			}
+
+			configMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+				AddFunc: loadConfigMap,
+				UpdateFunc: func(old, new interface{}) {
+					loadConfigMap(new)
+				},
+			})
+
+			configMapInformerFactory.Start(ctx.Done())
+			cacheSyncs = append(cacheSyncs, configMapInformer.Informer().HasSynced)
+		} else if policyTemplatesDir != "" {
+			go templateStore.WatchDirectory(ctx, policyTemplatesDir, policyTemplatesPollInterval)
+		}
+
+		// Serve the health checks and metrics, when enabled
+		srv := startServer(ctx, registry)
 
 		// Start informers
-		kubeInformerFactory.Start(stopCh)
-		kubeDefaultNsInformerFactory.Start(stopCh)
+		kubeInformerFactory.Start(ctx.Done())
+		kubeDefaultNsInformerFactory.Start(ctx.Done())
+		if dynamicDefaultNsInformerFactory != nil {
+			dynamicDefaultNsInformerFactory.Start(ctx.Done())
+		}
+		dynamicInformerFactory.Start(ctx.Done())
 
 		// Wait for caches
 		klog.Info("Waiting for informer caches to sync")
-		if ok := cache.WaitForCacheSync(stopCh, networkPolicyInformer.Informer().HasSynced, defaultNsEndpointsInformer.Informer().HasSynced); !ok {
+		if ok := cache.WaitForCacheSync(ctx.Done(), cacheSyncs...); !ok {
 			klog.Fatalf("failed to wait for caches to sync")
 		}
+		srv.SetReady()
+
+		// Periodically report the changes of the dry-run
+		go reportChanges(ctx, changes, dryRunSummaryInterval)
+
+		// Run the controller, on the leader only when leader election is
+		// enabled. The standby replicas keep their caches warm to take over.
+		runAsLeader(ctx, kubeClient, func(ctx context.Context) {
+			// Maintain the cluster-wide default deny policy
+			if calicoGlobalDefaultDeny {
+				go wait.UntilWithContext(ctx, func(ctx context.Context) {
+					if err := syncCalicoGlobalPolicy(ctx, dynamicClient, globalDefaultDeny, changes); err != nil {
+						klog.Errorf("failed to sync the global default deny policy: %v", err)
+					}
+				}, time.Minute*5)
+			}
 
-		// Run the controller
-		if err = controller.Run(2, stopCh); err != nil {
-			klog.Fatalf("error running controller: %v", err)
+			// Periodically report which namespaces use each profile
+			if enableNetworkProfiles && !dryRun {
+				go wait.UntilWithContext(ctx, func(ctx context.Context) {
+					updateNetworkProfileStatuses(ctx, dynamicClient, networkProfileLister, namespaceLister)
+				}, time.Second*30)
+			}
+
+			if err := controller.Run(ctx); err != nil {
+				klog.Fatalf("error running controller: %v", err)
+			}
+		})
+
+		if changes != nil {
+			fmt.Print(changes.Summary())
 		}
 	},
 }
 
-func generateNetworkPolicies(namespace *corev1.Namespace, apiServerEndpoints *corev1.Endpoints) []*networkingv1.NetworkPolicy {
-	policies := []*networkingv1.NetworkPolicy{}
+func generateNetworkPolicies(templateSet *templates.Set, cfg *config.Config, namespace *corev1.Namespace, profile *networkv1alpha1.NetworkProfile, apiServer []templates.EndpointSubset) ([]*networkingv1.NetworkPolicy, error) {
+	data := &templates.Data{
+		Config:    cfg,
+		Namespace: namespace,
+		Profile:   profile,
+		APIServer: apiServer,
+	}
 
 	// Namespace metadata
-	isSystem := false
 	if val, ok := namespace.ObjectMeta.Labels["namespace.statcan.gc.ca/purpose"]; ok {
-		isSystem = val == "system" || val == "daaas"
+		data.IsSystem = val == "system" || val == "daaas"
 	}
 
-	// Helpers
-	protocolTCP := corev1.ProtocolTCP
-	protocolUDP := corev1.ProtocolUDP
-
-	// Default deny all ingress traffic
-	policies = append(policies, &networkingv1.NetworkPolicy{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "default-deny",
-			Namespace: namespace.Name,
-			OwnerReferences: []metav1.OwnerReference{
-				*metav1.NewControllerRef(namespace, corev1.SchemeGroupVersion.WithKind("Namespace")),
-			},
-		},
-		Spec: networkingv1.NetworkPolicySpec{
-			PodSelector: metav1.LabelSelector{},
-			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
-		},
-	})
-
-	// Optionally allow same namespace is a label is set,
-	// but assume this label by default on system namespaces
-	allowSameNamespace := false
-	allowSameNamespaceValue, ok := namespace.ObjectMeta.Labels["network.statcan.gc.ca/allow-same-ns"]
-
-	if isSystem {
-		if !ok {
-			allowSameNamespace = true
-		} else {
+	if profile != nil {
+		// The profile replaces the legacy labels
+		data.AllowSameNamespace = profile.Spec.AllowSameNamespace
+		data.AllowIngressController = profile.Spec.AllowIngressController
+	} else {
+		// Optionally allow same namespace is a label is set,
+		// but assume this label by default on system namespaces
+		allowSameNamespaceValue, ok := namespace.ObjectMeta.Labels["network.statcan.gc.ca/allow-same-ns"]
+
+		if data.IsSystem {
+			if !ok {
+				data.AllowSameNamespace = true
+			} else {
+				allow, err := strconv.ParseBool(allowSameNamespaceValue)
+				if err != nil {
+					klog.Warningf("invalid boolean value %q for network.statcan.gc.ca/allow-same-ns on namespace %q; ignoring", allowSameNamespaceValue, namespace.Name)
+				} else {
+					data.AllowSameNamespace = allow
+				}
+			}
+		} else if ok {
 			allow, err := strconv.ParseBool(allowSameNamespaceValue)
 			if err != nil {
 				klog.Warningf("invalid boolean value %q for network.statcan.gc.ca/allow-same-ns on namespace %q; ignoring", allowSameNamespaceValue, namespace.Name)
 			} else {
-				allowSameNamespace = allow
+				data.AllowSameNamespace = allow
 			}
 		}
-	} else if ok {
-		allow, err := strconv.ParseBool(allowSameNamespaceValue)
-		if err != nil {
-			klog.Warningf("invalid boolean value %q for network.statcan.gc.ca/allow-same-ns on namespace %q; ignoring", allowSameNamespaceValue, namespace.Name)
-		} else {
-			allowSameNamespace = allow
-		}
-	}
-
-	if allowSameNamespace {
-		policies = append(policies, &networkingv1.NetworkPolicy{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "allow-same-namespace",
-				Namespace: namespace.Name,
-				OwnerReferences: []metav1.OwnerReference{
-					*metav1.NewControllerRef(namespace, corev1.SchemeGroupVersion.WithKind("Namespace")),
-				},
-			},
-			Spec: networkingv1.NetworkPolicySpec{
-				PodSelector: metav1.LabelSelector{},
-				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
-				Ingress: []networkingv1.NetworkPolicyIngressRule{
-					{
-						From: []networkingv1.NetworkPolicyPeer{
-							{
-								PodSelector: &metav1.LabelSelector{},
-							},
-						},
-					},
-				},
-				Egress: []networkingv1.NetworkPolicyEgressRule{
-					{
-						To: []networkingv1.NetworkPolicyPeer{
-							{
-								PodSelector: &metav1.LabelSelector{},
-							},
-						},
-					},
-				},
-			},
-		})
-	}
 
-	// Default allow ingress controller
-	if val, ok := namespace.ObjectMeta.Labels["network.statcan.gc.ca/allow-ingress-controller"]; ok {
-		allow, err := strconv.ParseBool(val)
-		if err != nil {
-			klog.Warningf("invalid boolean value %q for network.statcan.gc.ca/allow-ingress-controller on namespace %q; ignoring", val, namespace.Name)
-		} else if allow {
-			policies = append(policies, &networkingv1.NetworkPolicy{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      "allow-ingress-controller",
-					Namespace: namespace.Name,
-					OwnerReferences: []metav1.OwnerReference{
-						*metav1.NewControllerRef(namespace, corev1.SchemeGroupVersion.WithKind("Namespace")),
-					},
-				},
-				Spec: networkingv1.NetworkPolicySpec{
-					PodSelector: metav1.LabelSelector{},
-					PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
-					Ingress: []networkingv1.NetworkPolicyIngressRule{
-						{
-							From: []networkingv1.NetworkPolicyPeer{
-								{
-									NamespaceSelector: &metav1.LabelSelector{
-										MatchLabels: map[string]string{
-											"install.operator.istio.io/owner-name": "istio",
-											"namespace.statcan.gc.ca/purpose":      "system",
-										},
-									},
-									PodSelector: &metav1.LabelSelector{
-										MatchLabels: map[string]string{
-											"istio": "ingressgateway",
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			})
+		// Optionally allow the ingress controller
+		if val, ok := namespace.ObjectMeta.Labels["network.statcan.gc.ca/allow-ingress-controller"]; ok {
+			allow, err := strconv.ParseBool(val)
+			if err != nil {
+				klog.Warningf("invalid boolean value %q for network.statcan.gc.ca/allow-ingress-controller on namespace %q; ignoring", val, namespace.Name)
+			} else {
+				data.AllowIngressController = allow
+			}
 		}
 	}
 
-	// Allow access to core system components necessary for standard operation
-	// (e.g., DNS)
-	dnsPort := intstr.FromInt(53)
-
-	policies = append(policies, &networkingv1.NetworkPolicy{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "allow-core-system",
-			Namespace: namespace.Name,
-			OwnerReferences: []metav1.OwnerReference{
-				*metav1.NewControllerRef(namespace, corev1.SchemeGroupVersion.WithKind("Namespace")),
-			},
-		},
-		Spec: networkingv1.NetworkPolicySpec{
-			PodSelector: metav1.LabelSelector{},
-			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
-			Ingress: []networkingv1.NetworkPolicyIngressRule{
-				{
-					From: []networkingv1.NetworkPolicyPeer{
-						{
-							NamespaceSelector: &metav1.LabelSelector{
-								MatchLabels: map[string]string{
-									"install.operator.istio.io/owner-name": "istio",
-									"namespace.statcan.gc.ca/purpose":      "system",
-								},
-							},
-							PodSelector: &metav1.LabelSelector{
-								MatchExpressions: []metav1.LabelSelectorRequirement{
-									{
-										Key:      "istio",
-										Operator: metav1.LabelSelectorOpIn,
-										Values:   []string{"pilot"},
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-			Egress: []networkingv1.NetworkPolicyEgressRule{
-				{
-					To: []networkingv1.NetworkPolicyPeer{
-						{
-							NamespaceSelector: &metav1.LabelSelector{
-								MatchLabels: map[string]string{
-									"kubernetes.io/cluster-service": "true",
-								},
-							},
-							PodSelector: &metav1.LabelSelector{
-								MatchLabels: map[string]string{
-									"k8s-app": "kube-dns",
-								},
-							},
-						},
-					},
-					Ports: []networkingv1.NetworkPolicyPort{
-						{
-							Protocol: &protocolUDP,
-							Port:     &dnsPort,
-						},
-						{
-							Protocol: &protocolTCP,
-							Port:     &dnsPort,
-						},
-					},
-				},
-				{
-					To: []networkingv1.NetworkPolicyPeer{
-						{
-							NamespaceSelector: &metav1.LabelSelector{
-								MatchLabels: map[string]string{
-									"install.operator.istio.io/owner-name": "istio",
-									"namespace.statcan.gc.ca/purpose":      "system",
-								},
-							},
-							PodSelector: &metav1.LabelSelector{
-								MatchExpressions: []metav1.LabelSelectorRequirement{
-									{
-										Key:      "istio",
-										Operator: metav1.LabelSelectorOpIn,
-										Values:   []string{"pilot"},
-									},
-								},
-							},
-						},
-					},
-				},
-				{
-					To: []networkingv1.NetworkPolicyPeer{
-						{
-							NamespaceSelector: &metav1.LabelSelector{
-								MatchLabels: map[string]string{
-									"install.operator.istio.io/owner-name": "istio",
-									"namespace.statcan.gc.ca/purpose":      "system",
-								},
-							},
-							PodSelector: &metav1.LabelSelector{
-								MatchExpressions: []metav1.LabelSelectorRequirement{
-									{
-										Key:      "istio",
-										Operator: metav1.LabelSelectorOpIn,
-										Values:   []string{"mixer"},
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-		},
-	})
-
-	// Allow access to kube-apiserver to workloads with the necessary label
-	// However, system namespaces will have this by default.
-	apiServerPolicy := &networkingv1.NetworkPolicy{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "allow-kube-apiserver",
-			Namespace: namespace.Name,
-			OwnerReferences: []metav1.OwnerReference{
-				*metav1.NewControllerRef(namespace, corev1.SchemeGroupVersion.WithKind("Namespace")),
-			},
-		},
-		Spec: networkingv1.NetworkPolicySpec{
-			PodSelector: metav1.LabelSelector{},
-			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
-			Egress:      []networkingv1.NetworkPolicyEgressRule{},
-		},
+	// Render the templates of the profile, or all templates by default
+	names := templateSet.Names()
+	if profile != nil && len(profile.Spec.Templates) > 0 {
+		names = profile.Spec.Templates
 	}
 
-	if !isSystem {
-		apiServerPolicy.Spec.PodSelector = metav1.LabelSelector{
-			MatchLabels: map[string]string{
-				"network.statcan.gc.ca/allow-kube-apiserver": "true",
-			},
+	policies := []*networkingv1.NetworkPolicy{}
+	for _, name := range names {
+		rendered, err := templateSet.RenderTemplate(name, data)
+		if err != nil {
+			return nil, err
 		}
+		policies = append(policies, rendered...)
 	}
 
-	for _, subset := range apiServerEndpoints.Subsets {
-		egressRule := networkingv1.NetworkPolicyEgressRule{
-			To:    []networkingv1.NetworkPolicyPeer{},
-			Ports: []networkingv1.NetworkPolicyPort{},
+	// Ensure policies are always created in the namespace and owned by it,
+	// regardless of what the templates specify.
+	for _, policy := range policies {
+		policy.Namespace = namespace.Name
+		if policy.Labels == nil {
+			policy.Labels = map[string]string{}
 		}
-
-		for _, address := range subset.Addresses {
-			ip := net.ParseIP(address.IP)
-
-			if ip == nil {
-				klog.Warningf("failed to parse IP: %v", address.IP)
-				continue
-			}
-
-			netmask := 0
-			if ip.To4() != nil {
-				netmask = 32
-			} else if ip.To16() != nil {
-				netmask = 128
-			} else {
-				klog.Warningf("skipping %q: unable to detmine if IPv4 or IPv6 address", address.IP)
-				continue
-			}
-
-			egressRule.To = append(egressRule.To, networkingv1.NetworkPolicyPeer{
-				IPBlock: &networkingv1.IPBlock{
-					CIDR: fmt.Sprintf("%s/%d", ip.String(), netmask),
-				},
-			})
+		policy.Labels[managedByLabel] = managedByValue
+		policy.OwnerReferences = []metav1.OwnerReference{
+			*metav1.NewControllerRef(namespace, corev1.SchemeGroupVersion.WithKind("Namespace")),
 		}
+	}
 
-		for _, port := range subset.Ports {
-			portNum := intstr.FromInt(int(port.Port))
-			egressRule.Ports = append(egressRule.Ports, networkingv1.NetworkPolicyPort{
-				Protocol: &port.Protocol,
-				Port:     &portNum,
-			})
-		}
+	return policies, nil
+}
 
-		apiServerPolicy.Spec.Egress = append(apiServerPolicy.Spec.Egress, egressRule)
+// isManagedObject returns true if the object was created by the
+// controller, either because it carries the managed-by label or because
+// it is controlled by the namespace.
+func isManagedObject(namespace *corev1.Namespace, obj metav1.Object) bool {
+	if obj.GetLabels()[managedByLabel] == managedByValue {
+		return true
 	}
 
-	policies = append(policies, apiServerPolicy)
+	if ownerRef := metav1.GetControllerOf(obj); ownerRef != nil {
+		return ownerRef.Kind == "Namespace" && ownerRef.UID == namespace.UID
+	}
 
-	return policies
+	return false
 }
 
 func init() {
+	networkCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report the changes to the network policies as diffs, using server-side dry-run, without persisting them")
+	networkCmd.Flags().DurationVar(&dryRunSummaryInterval, "dry-run-summary-interval", time.Minute, "Interval at which the number of network policies which would change is printed in dry-run mode")
+	networkCmd.Flags().DurationVar(&reconcileTimeout, "reconcile-timeout", time.Minute, "Time after which the API requests made to sync the policies of a namespace are cancelled and the namespace is retried; disabled when 0")
+	networkCmd.Flags().StringVar(&metricsAddr, "metrics-addr", ":8080", "Address serving the health checks and the Prometheus metrics of the controller; disabled when empty")
+	addLeaderElectionFlags(networkCmd, "namespace-controller-network")
+	addNamespaceSelectionFlags(networkCmd)
+	networkCmd.PersistentFlags().StringVar(&networkConfigPath, "config", "", "Path to the configuration file describing the platform components (Istio, DNS) referenced by the policies")
+	networkCmd.Flags().StringVar(&policyBackendName, "policy-backend", policyBackendNetworking, "Policy implementation to generate: networking (networking.k8s.io/v1 NetworkPolicy), cilium (CiliumNetworkPolicy) or calico (projectcalico.org/v3 NetworkPolicy, see --calico-global-default-deny)")
+	networkCmd.Flags().BoolVar(&calicoGlobalDefaultDeny, "calico-global-default-deny", false, "Maintain a projectcalico.org/v3 GlobalNetworkPolicy denying the traffic of every namespace matching --namespace-selector (requires --policy-backend=calico)")
+	networkCmd.Flags().DurationVar(&apiServerDebounce, "apiserver-endpoints-debounce", time.Second*30, "Time to wait for the Kubernetes API server endpoints to settle before updating all namespaces")
+	networkCmd.Flags().BoolVar(&enableNetworkProfiles, "enable-network-profiles", false, "Select network policies using NetworkProfile resources (requires the NetworkProfile CRD)")
+	networkCmd.PersistentFlags().StringVar(&policyTemplatesDir, "policy-templates-dir", "", "Path to a directory of NetworkPolicy templates (defaults to the built-in templates)")
+	networkCmd.Flags().StringVar(&policyTemplatesConfigMap, "policy-templates-configmap", "", "ConfigMap containing NetworkPolicy templates, as <namespace>/<name> (defaults to the built-in templates)")
+	networkCmd.Flags().DurationVar(&policyTemplatesPollInterval, "policy-templates-poll-interval", time.Second*10, "Interval at which the policy templates directory is checked for changes")
+
 	rootCmd.AddCommand(networkCmd)
 }
@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/StatCan/namespace-controller/pkg/dryrun"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	networkingv1listers "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+// policyBackend applies the network policies generated for a namespace
+// using a particular policy implementation.
+type policyBackend interface {
+	// Informer watches the policy objects applied by the backend.
+	Informer() cache.SharedIndexInformer
+
+	// Sync creates, updates and deletes the policy objects of the namespace
+	// so that they match the desired policies.
+	Sync(ctx context.Context, namespace *corev1.Namespace, desired []*networkingv1.NetworkPolicy) error
+}
+
+// Supported values of the --policy-backend flag
+const (
+	policyBackendNetworking = "networking"
+	policyBackendCilium     = "cilium"
+	policyBackendCalico     = "calico"
+)
+
+// newPolicyBackend creates the backend identified by name.
+func newPolicyBackend(name string, kubeClient kubernetes.Interface, kubeInformerFactory kubeinformers.SharedInformerFactory, dynamicClient dynamic.Interface, dynamicInformerFactory dynamicinformer.DynamicSharedInformerFactory, tracker *driftTracker, recorder record.EventRecorder, changes *dryrun.Recorder) (policyBackend, error) {
+	switch name {
+	case policyBackendNetworking:
+		networkPolicyInformer := kubeInformerFactory.Networking().V1().NetworkPolicies()
+		return &networkingBackend{
+			kubeClient:            kubeClient,
+			networkPolicyInformer: networkPolicyInformer.Informer(),
+			networkPolicyLister:   networkPolicyInformer.Lister(),
+			tracker:               tracker,
+			recorder:              recorder,
+			changes:               changes,
+		}, nil
+	case policyBackendCilium:
+		return newUnstructuredBackend(ciliumNetworkPoliciesResource, toCiliumNetworkPolicy, dynamicClient, dynamicInformerFactory, tracker, recorder, changes), nil
+	case policyBackendCalico:
+		return newUnstructuredBackend(calicoNetworkPoliciesResource, toCalicoNetworkPolicy, dynamicClient, dynamicInformerFactory, tracker, recorder, changes), nil
+	default:
+		return nil, fmt.Errorf("unknown policy backend %q: expected one of %s, %s or %s", name, policyBackendNetworking, policyBackendCilium, policyBackendCalico)
+	}
+}
+
+// networkingBackend applies networking.k8s.io/v1 NetworkPolicies.
+type networkingBackend struct {
+	kubeClient            kubernetes.Interface
+	networkPolicyInformer cache.SharedIndexInformer
+	networkPolicyLister   networkingv1listers.NetworkPolicyLister
+	tracker               *driftTracker
+	recorder              record.EventRecorder
+	changes               *dryrun.Recorder
+}
+
+func (b *networkingBackend) Informer() cache.SharedIndexInformer {
+	return b.networkPolicyInformer
+}
+
+func (b *networkingBackend) Sync(ctx context.Context, namespace *corev1.Namespace, policies []*networkingv1.NetworkPolicy) error {
+	desired := map[string]bool{}
+	for _, policy := range policies {
+		desired[policy.Name] = true
+
+		// Was the policy modified or deleted outside of the controller?
+		drifted := b.tracker.Clear(policy.Namespace, policy.Name)
+
+		currentPolicy, err := b.networkPolicyLister.NetworkPolicies(policy.Namespace).Get(policy.Name)
+		if errors.IsNotFound(err) {
+			klog.Infof("creating network policy %s/%s", policy.Namespace, policy.Name)
+			if err := b.changes.Record(dryrun.Create, "networkpolicies", policy.Namespace, policy.Name, nil, policy); err != nil {
+				return err
+			}
+
+			_, err = b.kubeClient.NetworkingV1().NetworkPolicies(policy.Namespace).Create(ctx, policy, metav1.CreateOptions{DryRun: dryrun.Options(b.changes != nil)})
+			if err != nil {
+				return err
+			}
+
+			if drifted && b.changes == nil {
+				b.recorder.Eventf(namespace, corev1.EventTypeNormal, "NetworkPolicyDriftCorrected", "Recreated network policy %s which was deleted", policy.Name)
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if !reflect.DeepEqual(policy.Spec, currentPolicy.Spec) || currentPolicy.Labels[managedByLabel] != managedByValue {
+			klog.Infof("updating network policy %s/%s", policy.Namespace, policy.Name)
+			updatedPolicy := currentPolicy.DeepCopy()
+			updatedPolicy.Spec = policy.Spec
+			if updatedPolicy.Labels == nil {
+				updatedPolicy.Labels = map[string]string{}
+			}
+			updatedPolicy.Labels[managedByLabel] = managedByValue
+
+			if err := b.changes.Record(dryrun.Update, "networkpolicies", policy.Namespace, policy.Name, currentPolicy, updatedPolicy); err != nil {
+				return err
+			}
+
+			result, err := b.kubeClient.NetworkingV1().NetworkPolicies(policy.Namespace).Update(ctx, updatedPolicy, metav1.UpdateOptions{DryRun: dryrun.Options(b.changes != nil)})
+			if err != nil {
+				return err
+			}
+			if b.changes == nil {
+				b.tracker.Wrote(result.Namespace, result.Name, result.ResourceVersion)
+			}
+
+			if drifted && b.changes == nil {
+				b.recorder.Eventf(namespace, corev1.EventTypeNormal, "NetworkPolicyDriftCorrected", "Restored network policy %s which was modified", policy.Name)
+			}
+		} else {
+			b.changes.Forget("networkpolicies", policy.Namespace, policy.Name)
+		}
+	}
+
+	// Remove policies owned by the controller which are no longer desired
+	// (e.g., when a namespace label granting access is removed)
+	existingPolicies, err := b.networkPolicyLister.NetworkPolicies(namespace.Name).List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list network policies of namespace %s: %v", namespace.Name, err)
+	}
+
+	for _, policy := range existingPolicies {
+		if desired[policy.Name] || !isManagedObject(namespace, policy) {
+			continue
+		}
+
+		klog.Infof("deleting network policy %s/%s", policy.Namespace, policy.Name)
+		if err := b.changes.Record(dryrun.Delete, "networkpolicies", policy.Namespace, policy.Name, policy, nil); err != nil {
+			return err
+		}
+
+		err = b.kubeClient.NetworkingV1().NetworkPolicies(policy.Namespace).Delete(ctx, policy.Name, metav1.DeleteOptions{DryRun: dryrun.Options(b.changes != nil)})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if b.changes == nil {
+			if err == nil {
+				b.tracker.Pruned(policy.Namespace, policy.Name)
+			} else {
+				// The policy was already deleted outside of the controller
+				b.tracker.Clear(policy.Namespace, policy.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// unstructuredBackend applies third-party policy resources through the
+// dynamic client, so that no vendor SDK is required.
+type unstructuredBackend struct {
+	resource      schema.GroupVersionResource
+	convert       func(*networkingv1.NetworkPolicy) (*unstructured.Unstructured, error)
+	dynamicClient dynamic.Interface
+	informer      cache.SharedIndexInformer
+	lister        cache.GenericLister
+	tracker       *driftTracker
+	recorder      record.EventRecorder
+	changes       *dryrun.Recorder
+}
+
+func newUnstructuredBackend(resource schema.GroupVersionResource, convert func(*networkingv1.NetworkPolicy) (*unstructured.Unstructured, error), dynamicClient dynamic.Interface, dynamicInformerFactory dynamicinformer.DynamicSharedInformerFactory, tracker *driftTracker, recorder record.EventRecorder, changes *dryrun.Recorder) *unstructuredBackend {
+	informer := dynamicInformerFactory.ForResource(resource)
+
+	return &unstructuredBackend{
+		resource:      resource,
+		convert:       convert,
+		dynamicClient: dynamicClient,
+		informer:      informer.Informer(),
+		lister:        informer.Lister(),
+		tracker:       tracker,
+		recorder:      recorder,
+		changes:       changes,
+	}
+}
+
+func (b *unstructuredBackend) Informer() cache.SharedIndexInformer {
+	return b.informer
+}
+
+func (b *unstructuredBackend) Sync(ctx context.Context, namespace *corev1.Namespace, policies []*networkingv1.NetworkPolicy) error {
+	client := b.dynamicClient.Resource(b.resource).Namespace(namespace.Name)
+
+	desired := map[string]bool{}
+	for _, policy := range policies {
+		desired[policy.Name] = true
+
+		obj, err := b.convert(policy)
+		if err != nil {
+			return fmt.Errorf("failed to convert network policy %s/%s: %v", policy.Namespace, policy.Name, err)
+		}
+		kind := obj.GetKind()
+
+		// Was the policy modified or deleted outside of the controller?
+		drifted := b.tracker.Clear(policy.Namespace, policy.Name)
+
+		current, err := b.lister.ByNamespace(namespace.Name).Get(policy.Name)
+		if errors.IsNotFound(err) {
+			klog.Infof("creating %s %s/%s", kind, policy.Namespace, policy.Name)
+			if err := b.changes.Record(dryrun.Create, b.resource.Resource, policy.Namespace, policy.Name, nil, obj); err != nil {
+				return err
+			}
+
+			_, err = client.Create(ctx, obj, metav1.CreateOptions{DryRun: dryrun.Options(b.changes != nil)})
+			if err != nil {
+				return err
+			}
+
+			if drifted && b.changes == nil {
+				b.recorder.Eventf(namespace, corev1.EventTypeNormal, "NetworkPolicyDriftCorrected", "Recreated %s %s which was deleted", kind, policy.Name)
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		currentObj := current.(*unstructured.Unstructured)
+		if !equality.Semantic.DeepEqual(currentObj.Object["spec"], obj.Object["spec"]) || currentObj.GetLabels()[managedByLabel] != managedByValue {
+			klog.Infof("updating %s %s/%s", kind, policy.Namespace, policy.Name)
+			updated := currentObj.DeepCopy()
+			updated.Object["spec"] = obj.Object["spec"]
+			updatedLabels := updated.GetLabels()
+			if updatedLabels == nil {
+				updatedLabels = map[string]string{}
+			}
+			updatedLabels[managedByLabel] = managedByValue
+			updated.SetLabels(updatedLabels)
+
+			if err := b.changes.Record(dryrun.Update, b.resource.Resource, policy.Namespace, policy.Name, currentObj, updated); err != nil {
+				return err
+			}
+
+			result, err := client.Update(ctx, updated, metav1.UpdateOptions{DryRun: dryrun.Options(b.changes != nil)})
+			if err != nil {
+				return err
+			}
+			if b.changes == nil {
+				b.tracker.Wrote(result.GetNamespace(), result.GetName(), result.GetResourceVersion())
+			}
+
+			if drifted && b.changes == nil {
+				b.recorder.Eventf(namespace, corev1.EventTypeNormal, "NetworkPolicyDriftCorrected", "Restored %s %s which was modified", kind, policy.Name)
+			}
+		} else {
+			b.changes.Forget(b.resource.Resource, policy.Namespace, policy.Name)
+		}
+	}
+
+	// Remove policies owned by the controller which are no longer desired
+	existing, err := b.lister.ByNamespace(namespace.Name).List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list %s of namespace %s: %v", b.resource.Resource, namespace.Name, err)
+	}
+
+	for _, obj := range existing {
+		u := obj.(*unstructured.Unstructured)
+		if desired[u.GetName()] || !isManagedObject(namespace, u) {
+			continue
+		}
+
+		klog.Infof("deleting %s %s/%s", u.GetKind(), u.GetNamespace(), u.GetName())
+		if err := b.changes.Record(dryrun.Delete, b.resource.Resource, u.GetNamespace(), u.GetName(), u, nil); err != nil {
+			return err
+		}
+
+		err = client.Delete(ctx, u.GetName(), metav1.DeleteOptions{DryRun: dryrun.Options(b.changes != nil)})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if b.changes == nil {
+			if err == nil {
+				b.tracker.Pruned(u.GetNamespace(), u.GetName())
+			} else {
+				// The policy was already deleted outside of the controller
+				b.tracker.Clear(u.GetNamespace(), u.GetName())
+			}
+		}
+	}
+
+	return nil
+}
+
+// newUnstructuredPolicy creates the skeleton of a third-party policy object
+// carrying the metadata of the generated NetworkPolicy.
+func newUnstructuredPolicy(gvk schema.GroupVersionKind, policy *networkingv1.NetworkPolicy, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetName(policy.Name)
+	obj.SetNamespace(policy.Namespace)
+	obj.SetLabels(policy.Labels)
+	obj.SetAnnotations(policy.Annotations)
+	obj.SetOwnerReferences(policy.OwnerReferences)
+	obj.Object["spec"] = spec
+
+	return obj
+}
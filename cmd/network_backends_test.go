@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/StatCan/namespace-controller/pkg/network/config"
+	"github.com/StatCan/namespace-controller/pkg/network/templates"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// backendTest drives a policy backend against fake clients. The informer
+// cache of the backend is refreshed from the client after each sync, as the
+// informers are not started.
+type backendTest struct {
+	backend policyBackend
+	refresh func(t *testing.T)
+	names   func(t *testing.T) []string
+}
+
+func newNetworkingBackendTest(t *testing.T, objects ...runtime.Object) *backendTest {
+	kubeClient := fake.NewSimpleClientset(objects...)
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, 0)
+
+	backend, err := newPolicyBackend(policyBackendNetworking, kubeClient, kubeInformerFactory, nil, nil, newDriftTracker(), record.NewFakeRecorder(10), nil)
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+
+	list := func(t *testing.T) []networkingv1.NetworkPolicy {
+		policies, err := kubeClient.NetworkingV1().NetworkPolicies("alpha").List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("failed to list network policies: %v", err)
+		}
+		return policies.Items
+	}
+
+	return &backendTest{
+		backend: backend,
+		refresh: func(t *testing.T) {
+			items := []interface{}{}
+			for i, policies := 0, list(t); i < len(policies); i++ {
+				items = append(items, &policies[i])
+			}
+			if err := backend.Informer().GetIndexer().Replace(items, ""); err != nil {
+				t.Fatalf("failed to refresh informer cache: %v", err)
+			}
+		},
+		names: func(t *testing.T) []string {
+			names := []string{}
+			for _, policy := range list(t) {
+				names = append(names, policy.Name)
+			}
+			sort.Strings(names)
+			return names
+		},
+	}
+}
+
+func newCalicoBackendTest(t *testing.T, objects ...runtime.Object) *backendTest {
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objects...)
+	dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 0)
+
+	backend, err := newPolicyBackend(policyBackendCalico, nil, nil, dynamicClient, dynamicInformerFactory, newDriftTracker(), record.NewFakeRecorder(10), nil)
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+
+	list := func(t *testing.T) []unstructured.Unstructured {
+		policies, err := dynamicClient.Resource(calicoNetworkPoliciesResource).Namespace("alpha").List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("failed to list calico network policies: %v", err)
+		}
+		return policies.Items
+	}
+
+	return &backendTest{
+		backend: backend,
+		refresh: func(t *testing.T) {
+			items := []interface{}{}
+			for i, policies := 0, list(t); i < len(policies); i++ {
+				items = append(items, &policies[i])
+			}
+			if err := backend.Informer().GetIndexer().Replace(items, ""); err != nil {
+				t.Fatalf("failed to refresh informer cache: %v", err)
+			}
+		},
+		names: func(t *testing.T) []string {
+			names := []string{}
+			for _, policy := range list(t) {
+				names = append(names, policy.GetName())
+			}
+			sort.Strings(names)
+			return names
+		},
+	}
+}
+
+func TestPolicyBackendPrune(t *testing.T) {
+	namespace := newTestNamespace("alpha", map[string]string{
+		"network.statcan.gc.ca/allow-same-ns": "true",
+	})
+	owner := *metav1.NewControllerRef(namespace, corev1.SchemeGroupVersion.WithKind("Namespace"))
+
+	// A policy created by the user, and policies of the controller which
+	// are no longer desired, identified by their label or their owner
+	foreign := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "foreign", Namespace: "alpha"}}
+	labelled := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "labelled", Namespace: "alpha", Labels: map[string]string{managedByLabel: managedByValue}}}
+	owned := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "owned", Namespace: "alpha", OwnerReferences: []metav1.OwnerReference{owner}}}
+
+	toUnstructured := func(policy *networkingv1.NetworkPolicy) runtime.Object {
+		return newUnstructuredPolicy(calicoNetworkPolicyKind, policy, map[string]interface{}{"selector": "all()"})
+	}
+
+	tests := []struct {
+		name string
+		new  func(t *testing.T) *backendTest
+	}{
+		{
+			name: "networking",
+			new: func(t *testing.T) *backendTest {
+				return newNetworkingBackendTest(t, foreign.DeepCopy(), labelled.DeepCopy(), owned.DeepCopy())
+			},
+		},
+		{
+			name: "calico",
+			new: func(t *testing.T) *backendTest {
+				return newCalicoBackendTest(t, toUnstructured(foreign), toUnstructured(labelled), toUnstructured(owned))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bt := test.new(t)
+
+			sync := func(namespace *corev1.Namespace) {
+				t.Helper()
+
+				policies, err := generateNetworkPolicies(templates.Default(), config.Default(), namespace, nil, testAPIServer)
+				if err != nil {
+					t.Fatalf("failed to generate network policies: %v", err)
+				}
+
+				bt.refresh(t)
+				if err := bt.backend.Sync(context.Background(), namespace, policies); err != nil {
+					t.Fatalf("failed to sync network policies: %v", err)
+				}
+			}
+
+			// The managed policies are kept while they are desired
+			sync(namespace)
+			expected := []string{"allow-core-system", "allow-kube-apiserver", "allow-same-namespace", "default-deny", "foreign"}
+			if names := bt.names(t); !reflect.DeepEqual(names, expected) {
+				t.Errorf("expected policies %v, got %v", expected, names)
+			}
+
+			// Removing the label prunes the policy it granted, and leaves
+			// the policies which are not managed by the controller alone
+			updated := namespace.DeepCopy()
+			delete(updated.Labels, "network.statcan.gc.ca/allow-same-ns")
+			sync(updated)
+			expected = []string{"allow-core-system", "allow-kube-apiserver", "default-deny", "foreign"}
+			if names := bt.names(t); !reflect.DeepEqual(names, expected) {
+				t.Errorf("expected policies %v, got %v", expected, names)
+			}
+		})
+	}
+}
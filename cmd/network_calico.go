@@ -0,0 +1,279 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/StatCan/namespace-controller/pkg/dryrun"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog"
+)
+
+var calicoNetworkPoliciesResource = schema.GroupVersionResource{Group: "projectcalico.org", Version: "v3", Resource: "networkpolicies"}
+var calicoNetworkPolicyKind = schema.GroupVersionKind{Group: "projectcalico.org", Version: "v3", Kind: "NetworkPolicy"}
+
+var calicoGlobalNetworkPoliciesResource = schema.GroupVersionResource{Group: "projectcalico.org", Version: "v3", Resource: "globalnetworkpolicies"}
+var calicoGlobalNetworkPolicyKind = schema.GroupVersionKind{Group: "projectcalico.org", Version: "v3", Kind: "GlobalNetworkPolicy"}
+
+// calicoGlobalDefaultDenyName is the name of the GlobalNetworkPolicy
+// denying the traffic of every selected namespace.
+const calicoGlobalDefaultDenyName = "namespace-controller-default-deny"
+
+// toCalicoNetworkPolicy converts a NetworkPolicy into an equivalent
+// projectcalico.org/v3 NetworkPolicy.
+func toCalicoNetworkPolicy(policy *networkingv1.NetworkPolicy) (*unstructured.Unstructured, error) {
+	spec := map[string]interface{}{
+		"selector": calicoSelector(&policy.Spec.PodSelector),
+	}
+
+	types := []interface{}{}
+	for _, policyType := range policy.Spec.PolicyTypes {
+		types = append(types, string(policyType))
+
+		switch policyType {
+		case networkingv1.PolicyTypeIngress:
+			rules := []interface{}{}
+			for _, rule := range policy.Spec.Ingress {
+				converted, err := calicoRules(rule.From, rule.Ports, "source")
+				if err != nil {
+					return nil, err
+				}
+				rules = append(rules, converted...)
+			}
+			spec["ingress"] = rules
+		case networkingv1.PolicyTypeEgress:
+			rules := []interface{}{}
+			for _, rule := range policy.Spec.Egress {
+				converted, err := calicoRules(rule.To, rule.Ports, "destination")
+				if err != nil {
+					return nil, err
+				}
+				rules = append(rules, converted...)
+			}
+			spec["egress"] = rules
+		}
+	}
+	spec["types"] = types
+
+	return newUnstructuredPolicy(calicoNetworkPolicyKind, policy, spec), nil
+}
+
+// calicoRules converts the peers and ports of a NetworkPolicy rule.
+// Calico rules match a single protocol, so a rule is generated for each
+// combination of peer and protocol. The peer is either the "source"
+// (ingress) or the "destination" (egress) of the traffic.
+func calicoRules(peers []networkingv1.NetworkPolicyPeer, ports []networkingv1.NetworkPolicyPort, peerField string) ([]interface{}, error) {
+	// Group the ports by protocol
+	portsByProtocol := map[string][]interface{}{}
+	for _, port := range ports {
+		protocol := string(corev1.ProtocolTCP)
+		if port.Protocol != nil {
+			protocol = string(*port.Protocol)
+		}
+
+		if port.Port == nil {
+			portsByProtocol[protocol] = nil
+			continue
+		}
+		if port.Port.IntValue() == 0 {
+			return nil, fmt.Errorf("named port %q is not supported by the calico backend", port.Port.String())
+		}
+		if existing, ok := portsByProtocol[protocol]; !ok || existing != nil {
+			portsByProtocol[protocol] = append(existing, int64(port.Port.IntValue()))
+		}
+	}
+
+	protocols := []string{}
+	for protocol := range portsByProtocol {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+
+	// A rule without peers matches all peers
+	entities := []map[string]interface{}{{}}
+	if len(peers) > 0 {
+		entities = []map[string]interface{}{}
+		for _, peer := range peers {
+			entities = append(entities, calicoEntity(peer))
+		}
+	}
+
+	rules := []interface{}{}
+	for _, entity := range entities {
+		if len(protocols) == 0 {
+			rule := map[string]interface{}{
+				"action": "Allow",
+			}
+			if len(entity) > 0 {
+				rule[peerField] = entity
+			}
+			rules = append(rules, rule)
+			continue
+		}
+
+		for _, protocol := range protocols {
+			rule := map[string]interface{}{
+				"action":   "Allow",
+				"protocol": protocol,
+			}
+
+			peer := map[string]interface{}{}
+			for key, value := range entity {
+				peer[key] = value
+			}
+			if len(peer) > 0 {
+				rule[peerField] = peer
+			}
+
+			// Ports are always matched on the destination
+			if ports := portsByProtocol[protocol]; ports != nil {
+				destination, _ := rule["destination"].(map[string]interface{})
+				if destination == nil {
+					destination = map[string]interface{}{}
+				}
+				destination["ports"] = ports
+				rule["destination"] = destination
+			}
+
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules, nil
+}
+
+// calicoEntity converts a NetworkPolicy peer into a Calico entity rule.
+func calicoEntity(peer networkingv1.NetworkPolicyPeer) map[string]interface{} {
+	entity := map[string]interface{}{}
+
+	if peer.IPBlock != nil {
+		entity["nets"] = []interface{}{peer.IPBlock.CIDR}
+		if len(peer.IPBlock.Except) > 0 {
+			entity["notNets"] = stringsToInterfaces(peer.IPBlock.Except)
+		}
+		return entity
+	}
+
+	if peer.PodSelector != nil {
+		entity["selector"] = calicoSelector(peer.PodSelector)
+	}
+	if peer.NamespaceSelector != nil {
+		entity["namespaceSelector"] = calicoSelector(peer.NamespaceSelector)
+	}
+
+	return entity
+}
+
+// calicoSelector converts a label selector into the Calico selector syntax.
+func calicoSelector(selector *metav1.LabelSelector) string {
+	terms := []string{}
+
+	keys := []string{}
+	for key := range selector.MatchLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		terms = append(terms, fmt.Sprintf("%s == '%s'", key, selector.MatchLabels[key]))
+	}
+
+	for _, requirement := range selector.MatchExpressions {
+		values := []string{}
+		for _, value := range requirement.Values {
+			values = append(values, fmt.Sprintf("'%s'", value))
+		}
+
+		switch requirement.Operator {
+		case metav1.LabelSelectorOpIn:
+			terms = append(terms, fmt.Sprintf("%s in { %s }", requirement.Key, strings.Join(values, ", ")))
+		case metav1.LabelSelectorOpNotIn:
+			terms = append(terms, fmt.Sprintf("%s not in { %s }", requirement.Key, strings.Join(values, ", ")))
+		case metav1.LabelSelectorOpExists:
+			terms = append(terms, fmt.Sprintf("has(%s)", requirement.Key))
+		case metav1.LabelSelectorOpDoesNotExist:
+			terms = append(terms, fmt.Sprintf("!has(%s)", requirement.Key))
+		}
+	}
+
+	if len(terms) == 0 {
+		return "all()"
+	}
+
+	return strings.Join(terms, " && ")
+}
+
+// newCalicoGlobalDefaultDeny returns the GlobalNetworkPolicy denying all the
+// traffic of the pods of the namespaces matching the label selector, unless
+// allowed by another policy. The policy has no rules: selecting the pods is
+// enough for Calico to deny the traffic which no policy allows. Unlike the
+// default-deny NetworkPolicies, it applies to a namespace as soon as it is created.
+func newCalicoGlobalDefaultDeny(namespaceSelector string) (*unstructured.Unstructured, error) {
+	selector, err := metav1.ParseToLabelSelector(namespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace selector %q: %w", namespaceSelector, err)
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	obj.SetGroupVersionKind(calicoGlobalNetworkPolicyKind)
+	obj.SetName(calicoGlobalDefaultDenyName)
+	obj.SetLabels(map[string]string{
+		managedByLabel: managedByValue,
+	})
+	obj.Object["spec"] = map[string]interface{}{
+		"selector":          "all()",
+		"namespaceSelector": calicoSelector(selector),
+		"types":             []interface{}{"Ingress", "Egress"},
+	}
+
+	return obj, nil
+}
+
+// syncCalicoGlobalPolicy creates or updates the cluster-scoped GlobalNetworkPolicy.
+func syncCalicoGlobalPolicy(ctx context.Context, dynamicClient dynamic.Interface, policy *unstructured.Unstructured, changes *dryrun.Recorder) error {
+	client := dynamicClient.Resource(calicoGlobalNetworkPoliciesResource)
+
+	current, err := client.Get(ctx, policy.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		klog.Infof("creating %s %s", policy.GetKind(), policy.GetName())
+		if err := changes.Record(dryrun.Create, calicoGlobalNetworkPoliciesResource.Resource, "", policy.GetName(), nil, policy); err != nil {
+			return err
+		}
+
+		_, err = client.Create(ctx, policy, metav1.CreateOptions{DryRun: dryrun.Options(changes != nil)})
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(current.Object["spec"], policy.Object["spec"]) && current.GetLabels()[managedByLabel] == managedByValue {
+		changes.Forget(calicoGlobalNetworkPoliciesResource.Resource, "", policy.GetName())
+		return nil
+	}
+
+	klog.Infof("updating %s %s", policy.GetKind(), policy.GetName())
+	updated := current.DeepCopy()
+	updated.Object["spec"] = policy.Object["spec"]
+	updatedLabels := updated.GetLabels()
+	if updatedLabels == nil {
+		updatedLabels = map[string]string{}
+	}
+	updatedLabels[managedByLabel] = managedByValue
+	updated.SetLabels(updatedLabels)
+
+	if err := changes.Record(dryrun.Update, calicoGlobalNetworkPoliciesResource.Resource, "", policy.GetName(), current, updated); err != nil {
+		return err
+	}
+
+	_, err = client.Update(ctx, updated, metav1.UpdateOptions{DryRun: dryrun.Options(changes != nil)})
+	return err
+}
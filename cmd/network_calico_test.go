@@ -0,0 +1,350 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/StatCan/namespace-controller/pkg/dryrun"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestToCalicoNetworkPolicyDefaultTemplates(t *testing.T) {
+	policies := renderDefaultPolicies(t, newTestNamespace("alpha", map[string]string{
+		"network.statcan.gc.ca/allow-same-ns":            "true",
+		"network.statcan.gc.ca/allow-ingress-controller": "true",
+	}))
+
+	tests := []struct {
+		policy   string
+		expected string
+	}{
+		{
+			// Policy types without rules deny all traffic
+			policy: "default-deny",
+			expected: `
+selector: all()
+types: [Ingress, Egress]
+ingress: []
+egress: []
+`,
+		},
+		{
+			// Selectors without a namespace selector match the namespace of the policy
+			policy: "allow-same-namespace",
+			expected: `
+selector: all()
+types: [Ingress, Egress]
+ingress:
+- action: Allow
+  source:
+    selector: all()
+egress:
+- action: Allow
+  destination:
+    selector: all()
+`,
+		},
+		{
+			policy: "allow-ingress-controller",
+			expected: `
+selector: all()
+types: [Ingress]
+ingress:
+- action: Allow
+  source:
+    namespaceSelector: install.operator.istio.io/owner-name == 'istio' && namespace.statcan.gc.ca/purpose == 'system'
+    selector: istio == 'ingressgateway'
+`,
+		},
+		{
+			// Rules match a single protocol, so the DNS rule is split by protocol
+			policy: "allow-core-system",
+			expected: `
+selector: all()
+types: [Ingress, Egress]
+ingress:
+- action: Allow
+  source:
+    namespaceSelector: install.operator.istio.io/owner-name == 'istio' && namespace.statcan.gc.ca/purpose == 'system'
+    selector: istio in { 'pilot' }
+egress:
+- action: Allow
+  protocol: TCP
+  destination:
+    namespaceSelector: kubernetes.io/cluster-service == 'true'
+    selector: k8s-app == 'kube-dns'
+    ports: [53]
+- action: Allow
+  protocol: UDP
+  destination:
+    namespaceSelector: kubernetes.io/cluster-service == 'true'
+    selector: k8s-app == 'kube-dns'
+    ports: [53]
+- action: Allow
+  destination:
+    namespaceSelector: install.operator.istio.io/owner-name == 'istio' && namespace.statcan.gc.ca/purpose == 'system'
+    selector: istio in { 'pilot' }
+- action: Allow
+  destination:
+    namespaceSelector: install.operator.istio.io/owner-name == 'istio' && namespace.statcan.gc.ca/purpose == 'system'
+    selector: istio in { 'mixer' }
+`,
+		},
+		{
+			policy: "allow-kube-apiserver",
+			expected: `
+selector: network.statcan.gc.ca/allow-kube-apiserver == 'true'
+types: [Egress]
+egress:
+- action: Allow
+  protocol: TCP
+  destination:
+    nets: [10.0.0.1/32]
+    ports: [443]
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			policy, ok := policies[test.policy]
+			if !ok {
+				t.Fatalf("policy %s was not rendered", test.policy)
+			}
+
+			obj, err := toCalicoNetworkPolicy(policy)
+			if err != nil {
+				t.Fatalf("failed to convert policy: %v", err)
+			}
+
+			if obj.GetKind() != "NetworkPolicy" || obj.GetAPIVersion() != "projectcalico.org/v3" || obj.GetNamespace() != "alpha" || obj.GetName() != test.policy {
+				t.Errorf("unexpected object %s %s %s/%s", obj.GetAPIVersion(), obj.GetKind(), obj.GetNamespace(), obj.GetName())
+			}
+
+			assertYAML(t, obj.Object["spec"], test.expected)
+		})
+	}
+}
+
+func TestToCalicoNetworkPolicyRules(t *testing.T) {
+	udp := corev1.ProtocolUDP
+	port := intstr.FromInt(8080)
+	otherPort := intstr.FromInt(8443)
+	namedPort := intstr.FromString("http")
+
+	tests := []struct {
+		name     string
+		egress   []networkingv1.NetworkPolicyEgressRule
+		expected string
+		err      string
+	}{
+		{
+			// A rule without peers allows all peers on its ports,
+			// and a protocol without a port allows all its ports
+			name: "no peers",
+			egress: []networkingv1.NetworkPolicyEgressRule{{
+				Ports: []networkingv1.NetworkPolicyPort{{Port: &port}, {Port: &otherPort}, {Protocol: &udp}},
+			}},
+			expected: `
+selector: all()
+types: [Egress]
+egress:
+- action: Allow
+  protocol: TCP
+  destination:
+    ports: [8080, 8443]
+- action: Allow
+  protocol: UDP
+`,
+		},
+		{
+			name: "ip block",
+			egress: []networkingv1.NetworkPolicyEgressRule{{
+				To: []networkingv1.NetworkPolicyPeer{{
+					IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}},
+				}},
+			}},
+			expected: `
+selector: all()
+types: [Egress]
+egress:
+- action: Allow
+  destination:
+    nets: [10.0.0.0/8]
+    notNets: [10.1.0.0/16]
+`,
+		},
+		{
+			name: "selector expressions",
+			egress: []networkingv1.NetworkPolicyEgressRule{{
+				To: []networkingv1.NetworkPolicyPeer{{
+					PodSelector: &metav1.LabelSelector{
+						MatchExpressions: []metav1.LabelSelectorRequirement{
+							{Key: "app", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"a", "b"}},
+							{Key: "tier", Operator: metav1.LabelSelectorOpExists},
+							{Key: "legacy", Operator: metav1.LabelSelectorOpDoesNotExist},
+						},
+					},
+				}},
+			}},
+			expected: `
+selector: all()
+types: [Egress]
+egress:
+- action: Allow
+  destination:
+    selector: app not in { 'a', 'b' } && has(tier) && !has(legacy)
+`,
+		},
+		{
+			name: "named port",
+			egress: []networkingv1.NetworkPolicyEgressRule{{
+				Ports: []networkingv1.NetworkPolicyPort{{Port: &namedPort}},
+			}},
+			err: `named port "http" is not supported`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := &networkingv1.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "alpha"},
+				Spec: networkingv1.NetworkPolicySpec{
+					PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+					Egress:      test.egress,
+				},
+			}
+
+			obj, err := toCalicoNetworkPolicy(policy)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to convert policy: %v", err)
+			}
+
+			assertYAML(t, obj.Object["spec"], test.expected)
+		})
+	}
+}
+
+func TestSyncCalicoGlobalPolicy(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	policy, err := newCalicoGlobalDefaultDeny("!control-plane")
+	if err != nil {
+		t.Fatalf("failed to create global policy: %v", err)
+	}
+
+	// The policy is created when missing
+	if err := syncCalicoGlobalPolicy(context.Background(), client, policy, nil); err != nil {
+		t.Fatalf("failed to sync global policy: %v", err)
+	}
+
+	current, err := client.Resource(calicoGlobalNetworkPoliciesResource).Get(context.Background(), calicoGlobalDefaultDenyName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get global policy: %v", err)
+	}
+	assertYAML(t, current.Object["spec"], `
+selector: all()
+namespaceSelector: "!has(control-plane)"
+types: [Ingress, Egress]
+`)
+
+	// The policy is left alone when it is up to date
+	client.ClearActions()
+	if err := syncCalicoGlobalPolicy(context.Background(), client, policy, nil); err != nil {
+		t.Fatalf("failed to sync global policy: %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() != "get" {
+			t.Errorf("unexpected %s of the up to date global policy", action.GetVerb())
+		}
+	}
+
+	// The policy is restored when modified
+	current.Object["spec"] = map[string]interface{}{"selector": "all()"}
+	if _, err := client.Resource(calicoGlobalNetworkPoliciesResource).Update(context.Background(), current, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to modify global policy: %v", err)
+	}
+
+	client.ClearActions()
+	if err := syncCalicoGlobalPolicy(context.Background(), client, policy, nil); err != nil {
+		t.Fatalf("failed to sync global policy: %v", err)
+	}
+
+	updated := false
+	for _, action := range client.Actions() {
+		if _, ok := action.(k8stesting.UpdateAction); ok {
+			updated = true
+		}
+	}
+	if !updated {
+		t.Errorf("expected the modified global policy to be updated")
+	}
+}
+
+func TestSyncCalicoGlobalPolicyDryRun(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	policy, err := newCalicoGlobalDefaultDeny("!control-plane")
+	if err != nil {
+		t.Fatalf("failed to create global policy: %v", err)
+	}
+
+	var out bytes.Buffer
+	changes := dryrun.NewRecorder(&out)
+
+	// The creation of the missing policy is recorded
+	if err := syncCalicoGlobalPolicy(context.Background(), client, policy, changes); err != nil {
+		t.Fatalf("failed to sync global policy: %v", err)
+	}
+	expected := "dry-run summary: 1 objects would change\n  globalnetworkpolicies: 1 to create, 0 to update, 0 to delete\n"
+	if summary := changes.Summary(); summary != expected {
+		t.Errorf("expected summary %q, got %q", expected, summary)
+	}
+	if !strings.Contains(out.String(), "# dry-run: would create globalnetworkpolicies/"+calicoGlobalDefaultDenyName) {
+		t.Errorf("expected the creation to be printed, got:\n%s", out.String())
+	}
+
+	// The update of a modified policy is recorded. The fake client does
+	// not implement dry-run, so the policy was created above.
+	changes = dryrun.NewRecorder(&out)
+	current, err := client.Resource(calicoGlobalNetworkPoliciesResource).Get(context.Background(), calicoGlobalDefaultDenyName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get global policy: %v", err)
+	}
+	current.Object["spec"] = map[string]interface{}{"selector": "all()"}
+	if _, err := client.Resource(calicoGlobalNetworkPoliciesResource).Update(context.Background(), current, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to modify global policy: %v", err)
+	}
+
+	if err := syncCalicoGlobalPolicy(context.Background(), client, policy, changes); err != nil {
+		t.Fatalf("failed to sync global policy: %v", err)
+	}
+	expected = "dry-run summary: 1 objects would change\n  globalnetworkpolicies: 0 to create, 1 to update, 0 to delete\n"
+	if summary := changes.Summary(); summary != expected {
+		t.Errorf("expected summary %q, got %q", expected, summary)
+	}
+
+	// The update is forgotten once the policy is up to date
+	if err := syncCalicoGlobalPolicy(context.Background(), client, policy, nil); err != nil {
+		t.Fatalf("failed to sync global policy: %v", err)
+	}
+	if err := syncCalicoGlobalPolicy(context.Background(), client, policy, changes); err != nil {
+		t.Fatalf("failed to sync global policy: %v", err)
+	}
+	expected = "dry-run summary: 0 objects would change\n"
+	if summary := changes.Summary(); summary != expected {
+		t.Errorf("expected summary %q, got %q", expected, summary)
+	}
+}
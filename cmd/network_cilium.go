@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"fmt"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var ciliumNetworkPoliciesResource = schema.GroupVersionResource{Group: "cilium.io", Version: "v2", Resource: "ciliumnetworkpolicies"}
+var ciliumNetworkPolicyKind = schema.GroupVersionKind{Group: "cilium.io", Version: "v2", Kind: "CiliumNetworkPolicy"}
+
+// Cilium label keys identifying the namespace of an endpoint
+const (
+	ciliumPodNamespaceLabel    = "k8s:io.kubernetes.pod.namespace"
+	ciliumNamespaceLabelPrefix = "k8s:io.cilium.k8s.namespace.labels."
+)
+
+// toCiliumNetworkPolicy converts a NetworkPolicy into an equivalent
+// cilium.io/v2 CiliumNetworkPolicy.
+func toCiliumNetworkPolicy(policy *networkingv1.NetworkPolicy) (*unstructured.Unstructured, error) {
+	spec := map[string]interface{}{
+		"endpointSelector": ciliumSelector(&policy.Spec.PodSelector, ""),
+	}
+
+	for _, policyType := range policy.Spec.PolicyTypes {
+		switch policyType {
+		case networkingv1.PolicyTypeIngress:
+			// An empty rule enables default deny
+			rules := []interface{}{map[string]interface{}{}}
+			for _, rule := range policy.Spec.Ingress {
+				converted, err := ciliumRules(policy.Namespace, rule.From, rule.Ports, "from")
+				if err != nil {
+					return nil, err
+				}
+				rules = append(rules, converted...)
+			}
+			spec["ingress"] = rules
+		case networkingv1.PolicyTypeEgress:
+			rules := []interface{}{map[string]interface{}{}}
+			for _, rule := range policy.Spec.Egress {
+				converted, err := ciliumRules(policy.Namespace, rule.To, rule.Ports, "to")
+				if err != nil {
+					return nil, err
+				}
+				rules = append(rules, converted...)
+			}
+			spec["egress"] = rules
+		}
+	}
+
+	return newUnstructuredPolicy(ciliumNetworkPolicyKind, policy, spec), nil
+}
+
+// ciliumRules converts the peers and ports of a NetworkPolicy rule.
+// Each peer becomes a separate rule, as Cilium rules select a single kind of peer.
+// The direction is "from" for ingress rules and "to" for egress rules.
+func ciliumRules(namespace string, peers []networkingv1.NetworkPolicyPeer, ports []networkingv1.NetworkPolicyPort, direction string) ([]interface{}, error) {
+	toPorts, err := ciliumPorts(ports)
+	if err != nil {
+		return nil, err
+	}
+
+	newRule := func(key string, value interface{}) map[string]interface{} {
+		rule := map[string]interface{}{
+			direction + key: value,
+		}
+		if toPorts != nil {
+			rule["toPorts"] = toPorts
+		}
+		return rule
+	}
+
+	// A rule without peers matches all peers
+	if len(peers) == 0 {
+		return []interface{}{newRule("Entities", []interface{}{"all"})}, nil
+	}
+
+	rules := []interface{}{}
+	for _, peer := range peers {
+		switch {
+		case peer.IPBlock != nil:
+			cidrSet := map[string]interface{}{
+				"cidr": peer.IPBlock.CIDR,
+			}
+			if len(peer.IPBlock.Except) > 0 {
+				cidrSet["except"] = stringsToInterfaces(peer.IPBlock.Except)
+			}
+			rules = append(rules, newRule("CIDRSet", []interface{}{cidrSet}))
+		case peer.NamespaceSelector != nil:
+			selector := ciliumSelector(peer.PodSelector, "")
+			namespaceSelector := ciliumSelector(peer.NamespaceSelector, ciliumNamespaceLabelPrefix)
+			mergeCiliumSelectors(selector, namespaceSelector)
+
+			// Without an explicit namespace key, Cilium restricts the
+			// selector to the namespace of the policy
+			appendCiliumExpression(selector, map[string]interface{}{
+				"key":      ciliumPodNamespaceLabel,
+				"operator": string(metav1.LabelSelectorOpExists),
+			})
+			rules = append(rules, newRule("Endpoints", []interface{}{selector}))
+		default:
+			selector := ciliumSelector(peer.PodSelector, "")
+			matchLabels, _ := selector["matchLabels"].(map[string]interface{})
+			if matchLabels == nil {
+				matchLabels = map[string]interface{}{}
+			}
+			matchLabels[ciliumPodNamespaceLabel] = namespace
+			selector["matchLabels"] = matchLabels
+			rules = append(rules, newRule("Endpoints", []interface{}{selector}))
+		}
+	}
+
+	return rules, nil
+}
+
+// ciliumPorts converts NetworkPolicy ports into a Cilium toPorts section.
+func ciliumPorts(ports []networkingv1.NetworkPolicyPort) ([]interface{}, error) {
+	if len(ports) == 0 {
+		return nil, nil
+	}
+
+	converted := []interface{}{}
+	for _, port := range ports {
+		protocol := "TCP"
+		if port.Protocol != nil {
+			protocol = string(*port.Protocol)
+		}
+
+		entry := map[string]interface{}{
+			"protocol": protocol,
+		}
+		if port.Port != nil {
+			if port.Port.IntValue() == 0 {
+				return nil, fmt.Errorf("named port %q is not supported by the cilium backend", port.Port.String())
+			}
+			entry["port"] = port.Port.String()
+		} else {
+			// Port 0 matches all ports
+			entry["port"] = "0"
+		}
+
+		converted = append(converted, entry)
+	}
+
+	return []interface{}{map[string]interface{}{"ports": converted}}, nil
+}
+
+// ciliumSelector converts a label selector, prefixing every key.
+func ciliumSelector(selector *metav1.LabelSelector, prefix string) map[string]interface{} {
+	converted := map[string]interface{}{}
+	if selector == nil {
+		return converted
+	}
+
+	if len(selector.MatchLabels) > 0 {
+		matchLabels := map[string]interface{}{}
+		for key, value := range selector.MatchLabels {
+			matchLabels[prefix+key] = value
+		}
+		converted["matchLabels"] = matchLabels
+	}
+
+	for _, requirement := range selector.MatchExpressions {
+		expression := map[string]interface{}{
+			"key":      prefix + requirement.Key,
+			"operator": string(requirement.Operator),
+		}
+		if len(requirement.Values) > 0 {
+			expression["values"] = stringsToInterfaces(requirement.Values)
+		}
+		appendCiliumExpression(converted, expression)
+	}
+
+	return converted
+}
+
+// mergeCiliumSelectors adds the requirements of src to dst.
+func mergeCiliumSelectors(dst, src map[string]interface{}) {
+	if matchLabels, ok := src["matchLabels"].(map[string]interface{}); ok {
+		dstLabels, _ := dst["matchLabels"].(map[string]interface{})
+		if dstLabels == nil {
+			dstLabels = map[string]interface{}{}
+		}
+		for key, value := range matchLabels {
+			dstLabels[key] = value
+		}
+		dst["matchLabels"] = dstLabels
+	}
+
+	if expressions, ok := src["matchExpressions"].([]interface{}); ok {
+		for _, expression := range expressions {
+			appendCiliumExpression(dst, expression.(map[string]interface{}))
+		}
+	}
+}
+
+func appendCiliumExpression(selector map[string]interface{}, expression map[string]interface{}) {
+	expressions, _ := selector["matchExpressions"].([]interface{})
+	selector["matchExpressions"] = append(expressions, expression)
+}
+
+// stringsToInterfaces converts a string slice into its unstructured form.
+func stringsToInterfaces(values []string) []interface{} {
+	converted := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		converted = append(converted, value)
+	}
+	return converted
+}
@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestToCiliumNetworkPolicyDefaultTemplates(t *testing.T) {
+	policies := renderDefaultPolicies(t, newTestNamespace("alpha", map[string]string{
+		"network.statcan.gc.ca/allow-same-ns":            "true",
+		"network.statcan.gc.ca/allow-ingress-controller": "true",
+	}))
+
+	tests := []struct {
+		policy   string
+		expected string
+	}{
+		{
+			// Empty rules enable default deny
+			policy: "default-deny",
+			expected: `
+endpointSelector: {}
+ingress:
+- {}
+egress:
+- {}
+`,
+		},
+		{
+			// Pod selectors are restricted to the namespace of the policy
+			policy: "allow-same-namespace",
+			expected: `
+endpointSelector: {}
+ingress:
+- {}
+- fromEndpoints:
+  - matchLabels:
+      k8s:io.kubernetes.pod.namespace: alpha
+egress:
+- {}
+- toEndpoints:
+  - matchLabels:
+      k8s:io.kubernetes.pod.namespace: alpha
+`,
+		},
+		{
+			// Namespace labels are prefixed, and any namespace is allowed
+			policy: "allow-ingress-controller",
+			expected: `
+endpointSelector: {}
+ingress:
+- {}
+- fromEndpoints:
+  - matchLabels:
+      istio: ingressgateway
+      k8s:io.cilium.k8s.namespace.labels.install.operator.istio.io/owner-name: istio
+      k8s:io.cilium.k8s.namespace.labels.namespace.statcan.gc.ca/purpose: system
+    matchExpressions:
+    - key: k8s:io.kubernetes.pod.namespace
+      operator: Exists
+`,
+		},
+		{
+			policy: "allow-core-system",
+			expected: `
+endpointSelector: {}
+ingress:
+- {}
+- fromEndpoints:
+  - matchLabels:
+      k8s:io.cilium.k8s.namespace.labels.install.operator.istio.io/owner-name: istio
+      k8s:io.cilium.k8s.namespace.labels.namespace.statcan.gc.ca/purpose: system
+    matchExpressions:
+    - key: istio
+      operator: In
+      values: [pilot]
+    - key: k8s:io.kubernetes.pod.namespace
+      operator: Exists
+egress:
+- {}
+- toEndpoints:
+  - matchLabels:
+      k8s-app: kube-dns
+      k8s:io.cilium.k8s.namespace.labels.kubernetes.io/cluster-service: "true"
+    matchExpressions:
+    - key: k8s:io.kubernetes.pod.namespace
+      operator: Exists
+  toPorts:
+  - ports:
+    - port: "53"
+      protocol: UDP
+    - port: "53"
+      protocol: TCP
+- toEndpoints:
+  - matchLabels:
+      k8s:io.cilium.k8s.namespace.labels.install.operator.istio.io/owner-name: istio
+      k8s:io.cilium.k8s.namespace.labels.namespace.statcan.gc.ca/purpose: system
+    matchExpressions:
+    - key: istio
+      operator: In
+      values: [pilot]
+    - key: k8s:io.kubernetes.pod.namespace
+      operator: Exists
+- toEndpoints:
+  - matchLabels:
+      k8s:io.cilium.k8s.namespace.labels.install.operator.istio.io/owner-name: istio
+      k8s:io.cilium.k8s.namespace.labels.namespace.statcan.gc.ca/purpose: system
+    matchExpressions:
+    - key: istio
+      operator: In
+      values: [mixer]
+    - key: k8s:io.kubernetes.pod.namespace
+      operator: Exists
+`,
+		},
+		{
+			policy: "allow-kube-apiserver",
+			expected: `
+endpointSelector:
+  matchLabels:
+    network.statcan.gc.ca/allow-kube-apiserver: "true"
+egress:
+- {}
+- toCIDRSet:
+  - cidr: 10.0.0.1/32
+  toPorts:
+  - ports:
+    - port: "443"
+      protocol: TCP
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			policy, ok := policies[test.policy]
+			if !ok {
+				t.Fatalf("policy %s was not rendered", test.policy)
+			}
+
+			obj, err := toCiliumNetworkPolicy(policy)
+			if err != nil {
+				t.Fatalf("failed to convert policy: %v", err)
+			}
+
+			if obj.GetKind() != "CiliumNetworkPolicy" || obj.GetNamespace() != "alpha" || obj.GetName() != test.policy {
+				t.Errorf("unexpected object %s %s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+			}
+			if obj.GetLabels()[managedByLabel] != managedByValue {
+				t.Errorf("expected the managed-by label, got labels %v", obj.GetLabels())
+			}
+
+			assertYAML(t, obj.Object["spec"], test.expected)
+		})
+	}
+}
+
+func TestToCiliumNetworkPolicyRules(t *testing.T) {
+	udp := corev1.ProtocolUDP
+	port := intstr.FromInt(8080)
+	namedPort := intstr.FromString("http")
+
+	tests := []struct {
+		name     string
+		ingress  []networkingv1.NetworkPolicyIngressRule
+		expected string
+		err      string
+	}{
+		{
+			// A rule without peers allows all peers on its ports
+			name: "no peers",
+			ingress: []networkingv1.NetworkPolicyIngressRule{{
+				Ports: []networkingv1.NetworkPolicyPort{{Port: &port}, {Protocol: &udp}},
+			}},
+			expected: `
+endpointSelector: {}
+ingress:
+- {}
+- fromEntities: [all]
+  toPorts:
+  - ports:
+    - port: "8080"
+      protocol: TCP
+    - port: "0"
+      protocol: UDP
+`,
+		},
+		{
+			name: "ip block",
+			ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{{
+					IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}},
+				}},
+			}},
+			expected: `
+endpointSelector: {}
+ingress:
+- {}
+- fromCIDRSet:
+  - cidr: 10.0.0.0/8
+    except: [10.1.0.0/16]
+`,
+		},
+		{
+			name: "named port",
+			ingress: []networkingv1.NetworkPolicyIngressRule{{
+				Ports: []networkingv1.NetworkPolicyPort{{Port: &namedPort}},
+			}},
+			err: `named port "http" is not supported`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := &networkingv1.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "alpha"},
+				Spec: networkingv1.NetworkPolicySpec{
+					PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+					Ingress:     test.ingress,
+				},
+			}
+
+			obj, err := toCiliumNetworkPolicy(policy)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to convert policy: %v", err)
+			}
+
+			assertYAML(t, obj.Object["spec"], test.expected)
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"sync"
+
+	"github.com/StatCan/namespace-controller/pkg/controllers/namespaces"
+	"k8s.io/apimachinery/pkg/api/meta"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// driftTracker remembers the managed network policies which were modified
+// or deleted since the controller last reconciled them, so that repairing
+// them can be reported as drift rather than as a regular update.
+type driftTracker struct {
+	mu      sync.Mutex
+	changed map[string]bool
+
+	// written holds the resourceVersion of the policies last updated by the
+	// controller, and pruned the policies it deleted, so that the events
+	// caused by its own writes are not mistaken for drift
+	written map[string]string
+	pruned  map[string]bool
+}
+
+func newDriftTracker() *driftTracker {
+	return &driftTracker{
+		changed: map[string]bool{},
+		written: map[string]string{},
+		pruned:  map[string]bool{},
+	}
+}
+
+// Wrote records the resourceVersion of a policy updated by the controller.
+func (t *driftTracker) Wrote(namespace, name, resourceVersion string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.written[namespace+"/"+name] = resourceVersion
+}
+
+// Pruned records that the controller deleted the policy, forgetting it.
+func (t *driftTracker) Pruned(namespace, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := namespace + "/" + name
+	delete(t.changed, key)
+	delete(t.written, key)
+	t.pruned[key] = true
+}
+
+// MarkModified records that the policy was modified outside of a reconcile,
+// and returns false if the resourceVersion is the one written by the controller.
+func (t *driftTracker) MarkModified(namespace, name, resourceVersion string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := namespace + "/" + name
+	if written, ok := t.written[key]; ok && written == resourceVersion {
+		delete(t.written, key)
+		return false
+	}
+
+	t.changed[key] = true
+	return true
+}
+
+// MarkDeleted records that the policy was deleted outside of a reconcile,
+// and returns false if it was pruned by the controller.
+func (t *driftTracker) MarkDeleted(namespace, name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := namespace + "/" + name
+	delete(t.written, key)
+	if t.pruned[key] {
+		delete(t.pruned, key)
+		return false
+	}
+
+	t.changed[key] = true
+	return true
+}
+
+// Forget removes every record of the policy.
+func (t *driftTracker) Forget(namespace, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := namespace + "/" + name
+	delete(t.changed, key)
+	delete(t.written, key)
+	delete(t.pruned, key)
+}
+
+// Clear forgets the policy and returns true if it had been marked.
+func (t *driftTracker) Clear(namespace, name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := namespace + "/" + name
+	changed := t.changed[key]
+	delete(t.changed, key)
+
+	return changed
+}
+
+// networkPolicyEventHandlers queues the owning namespace when a managed
+// policy object is modified or deleted, so that the change is reverted.
+// Policies are handled generically so that any backend can be watched.
+func networkPolicyEventHandlers(controller *namespaces.Controller, namespaceLister corev1listers.NamespaceLister, tracker *driftTracker) cache.ResourceEventHandler {
+	return cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			policy, err := meta.Accessor(obj)
+			return err == nil && policy.GetLabels()[managedByLabel] == managedByValue
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				old, _ := meta.Accessor(oldObj)
+				new, _ := meta.Accessor(newObj)
+
+				// Skip informer re-syncs and changes that do not affect the spec,
+				// which are the only ones incrementing the generation
+				if old.GetResourceVersion() == new.GetResourceVersion() || old.GetGeneration() == new.GetGeneration() {
+					return
+				}
+
+				// Skip the updates made by the controller
+				if !tracker.MarkModified(new.GetNamespace(), new.GetName(), new.GetResourceVersion()) {
+					return
+				}
+
+				klog.Infof("network policy %s/%s was modified; queuing namespace <%s>", new.GetNamespace(), new.GetName(), new.GetNamespace())
+				controller.HandleObject(newObj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				policy, _ := meta.Accessor(obj)
+
+				// Skip the policies garbage collected with their namespace
+				namespace, err := namespaceLister.Get(policy.GetNamespace())
+				if err != nil || namespace.DeletionTimestamp != nil {
+					tracker.Forget(policy.GetNamespace(), policy.GetName())
+					return
+				}
+
+				// Skip the policies pruned by the controller
+				if !tracker.MarkDeleted(policy.GetNamespace(), policy.GetName()) {
+					return
+				}
+
+				klog.Infof("network policy %s/%s was deleted; queuing namespace <%s>", policy.GetNamespace(), policy.GetName(), policy.GetNamespace())
+				controller.HandleObject(obj)
+			},
+		},
+	}
+}
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/StatCan/namespace-controller/pkg/controllers/namespaces"
+	"github.com/StatCan/namespace-controller/pkg/network/config"
+	"github.com/StatCan/namespace-controller/pkg/network/templates"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDriftTrackerModified(t *testing.T) {
+	tracker := newDriftTracker()
+
+	// Updates made by the controller are not drift
+	tracker.Wrote("alpha", "default-deny", "10")
+	if tracker.MarkModified("alpha", "default-deny", "10") {
+		t.Errorf("expected the update of the controller not to be marked")
+	}
+	if tracker.Clear("alpha", "default-deny") {
+		t.Errorf("expected the update of the controller not to be reported as drift")
+	}
+
+	// Later updates are drift
+	if !tracker.MarkModified("alpha", "default-deny", "11") {
+		t.Errorf("expected an external update to be marked")
+	}
+	if !tracker.Clear("alpha", "default-deny") {
+		t.Errorf("expected an external update to be reported as drift")
+	}
+	if tracker.Clear("alpha", "default-deny") {
+		t.Errorf("expected the drift to be reported once")
+	}
+}
+
+func TestDriftTrackerDeleted(t *testing.T) {
+	tracker := newDriftTracker()
+
+	// Policies pruned by the controller are not drift
+	tracker.MarkModified("alpha", "allow-same-namespace", "10")
+	tracker.Pruned("alpha", "allow-same-namespace")
+	if tracker.MarkDeleted("alpha", "allow-same-namespace") {
+		t.Errorf("expected the pruned policy not to be marked")
+	}
+
+	// Policies deleted by others are drift
+	if !tracker.MarkDeleted("alpha", "default-deny") {
+		t.Errorf("expected an external deletion to be marked")
+	}
+	if !tracker.Clear("alpha", "default-deny") {
+		t.Errorf("expected an external deletion to be reported as drift")
+	}
+
+	if len(tracker.changed) != 0 || len(tracker.written) != 0 || len(tracker.pruned) != 0 {
+		t.Errorf("expected no records to be left, got %v, %v and %v", tracker.changed, tracker.written, tracker.pruned)
+	}
+}
+
+func TestDriftTrackerForget(t *testing.T) {
+	tracker := newDriftTracker()
+
+	tracker.Wrote("alpha", "default-deny", "10")
+	tracker.MarkModified("alpha", "default-deny", "11")
+	tracker.Pruned("alpha", "allow-same-namespace")
+	tracker.Forget("alpha", "default-deny")
+	tracker.Forget("alpha", "allow-same-namespace")
+
+	if len(tracker.changed) != 0 || len(tracker.written) != 0 || len(tracker.pruned) != 0 {
+		t.Errorf("expected no records to be left, got %v, %v and %v", tracker.changed, tracker.written, tracker.pruned)
+	}
+}
+
+// newVersionedClientset returns a fake clientset which versions the network
+// policies like the API server: the resourceVersion is incremented on each
+// write, and the generation when the spec changes.
+func newVersionedClientset(objects ...runtime.Object) *fake.Clientset {
+	kubeClient := fake.NewSimpleClientset(objects...)
+	resourceVersion := 0
+
+	kubeClient.PrependReactor("*", "networkpolicies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetVerb() != "create" && action.GetVerb() != "update" {
+			return false, nil, nil
+		}
+
+		policy := action.(k8stesting.CreateAction).GetObject().(*networkingv1.NetworkPolicy)
+		policy.Generation = 1
+		if current, err := kubeClient.Tracker().Get(action.GetResource(), policy.Namespace, policy.Name); err == nil {
+			currentPolicy := current.(*networkingv1.NetworkPolicy)
+			policy.Generation = currentPolicy.Generation
+			if !reflect.DeepEqual(policy.Spec, currentPolicy.Spec) {
+				policy.Generation++
+			}
+		}
+
+		// The reactors are called with the lock of the clientset held
+		resourceVersion++
+		policy.ResourceVersion = strconv.Itoa(resourceVersion)
+
+		return false, nil, nil
+	})
+
+	return kubeClient
+}
+
+func TestNetworkPolicyEventHandlers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	namespace := newTestNamespace("alpha", nil)
+	kubeClient := newVersionedClientset(namespace)
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, 0)
+	recorder := record.NewFakeRecorder(10)
+	tracker := newDriftTracker()
+
+	backend, err := newPolicyBackend(policyBackendNetworking, kubeClient, kubeInformerFactory, nil, nil, tracker, recorder, nil)
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+
+	synced := make(chan string, 10)
+	controller := namespaces.NewController("test", kubeInformerFactory.Core().V1().Namespaces(), func(ctx context.Context, namespace *corev1.Namespace) error {
+		synced <- namespace.Name
+
+		policies, err := generateNetworkPolicies(templates.Default(), config.Default(), namespace, nil, testAPIServer)
+		if err != nil {
+			return err
+		}
+
+		return backend.Sync(ctx, namespace, policies)
+	})
+
+	namespaceLister := kubeInformerFactory.Core().V1().Namespaces().Lister()
+	backend.Informer().AddEventHandler(networkPolicyEventHandlers(controller, namespaceLister, tracker))
+
+	kubeInformerFactory.Start(ctx.Done())
+	kubeInformerFactory.WaitForCacheSync(ctx.Done())
+	go func() {
+		if err := controller.Run(ctx); err != nil {
+			t.Errorf("failed to run controller: %v", err)
+		}
+	}()
+
+	// expectSyncs waits for the namespace to be synced n times, and ensures
+	// it is not synced again
+	expectSyncs := func(n int) {
+		t.Helper()
+
+		timeout := time.After(time.Second)
+		for i := 0; i < n; i++ {
+			select {
+			case <-synced:
+			case <-timeout:
+				t.Fatalf("expected %d syncs, got %d", n, i)
+			}
+		}
+
+		select {
+		case <-synced:
+			t.Fatalf("expected %d syncs, got more", n)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+
+	// The policies created by the controller do not trigger another sync
+	expectSyncs(1)
+
+	policies := kubeClient.NetworkingV1().NetworkPolicies("alpha")
+	policy, err := policies.Get(ctx, "default-deny", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get network policy: %v", err)
+	}
+	expected := policy.Spec
+
+	// A user edit to a managed policy is reverted, and the update of the
+	// controller reverting it does not trigger another sync
+	edited := policy.DeepCopy()
+	edited.Spec.PolicyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeEgress}
+	if _, err := policies.Update(ctx, edited, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update network policy: %v", err)
+	}
+	expectSyncs(1)
+
+	policy, err = policies.Get(ctx, "default-deny", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get network policy: %v", err)
+	}
+	if !reflect.DeepEqual(policy.Spec, expected) {
+		t.Errorf("expected the network policy to be reverted to %+v, got %+v", expected, policy.Spec)
+	}
+	if event := <-recorder.Events; event != "Normal NetworkPolicyDriftCorrected Restored network policy default-deny which was modified" {
+		t.Errorf("expected the drift to be reported, got %q", event)
+	}
+
+	// Changes which do not affect the spec are left alone
+	labelled := policy.DeepCopy()
+	labelled.Annotations = map[string]string{"note": "edited"}
+	if _, err := policies.Update(ctx, labelled, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update network policy: %v", err)
+	}
+	expectSyncs(0)
+
+	// A deleted managed policy is recreated
+	if err := policies.Delete(ctx, "default-deny", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete network policy: %v", err)
+	}
+	expectSyncs(1)
+
+	if _, err := policies.Get(ctx, "default-deny", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the network policy to be recreated: %v", err)
+	}
+	if event := <-recorder.Events; event != "Normal NetworkPolicyDriftCorrected Recreated network policy default-deny which was deleted" {
+		t.Errorf("expected the drift to be reported, got %q", event)
+	}
+}
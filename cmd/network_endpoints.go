@@ -0,0 +1,285 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+
+	"github.com/StatCan/namespace-controller/pkg/network/templates"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// endpointSlicesResources are the versions of the EndpointSlice resource,
+// by order of preference. The slices are consumed through the dynamic client,
+// as the typed client-go version in use only provides v1beta1, and v1beta1
+// is no longer served by recent clusters.
+var endpointSlicesResources = []schema.GroupVersionResource{
+	{Group: "discovery.k8s.io", Version: "v1", Resource: "endpointslices"},
+	{Group: "discovery.k8s.io", Version: "v1beta1", Resource: "endpointslices"},
+}
+
+// kubernetesServiceSelector selects the EndpointSlices of the `kubernetes` service.
+var kubernetesServiceSelector = labels.SelectorFromSet(labels.Set{discoveryv1beta1.LabelServiceName: "kubernetes"})
+
+// servedEndpointSlicesResource returns the preferred version of the
+// EndpointSlice resource served by the cluster.
+func servedEndpointSlicesResource(discoveryClient discovery.DiscoveryInterface) (schema.GroupVersionResource, error) {
+	var lastErr error
+	for _, resource := range endpointSlicesResources {
+		list, err := discoveryClient.ServerResourcesForGroupVersion(resource.GroupVersion().String())
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		for _, apiResource := range list.APIResources {
+			if apiResource.Name == resource.Resource {
+				return resource, nil
+			}
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("the %s resource is not served", endpointSlicesResources[0].GroupResource())
+	}
+
+	return schema.GroupVersionResource{}, lastErr
+}
+
+// hostCIDR converts an IP address into a single-host CIDR.
+func hostCIDR(address string) (string, bool) {
+	ip := net.ParseIP(address)
+
+	if ip == nil {
+		klog.Warningf("failed to parse IP: %v", address)
+		return "", false
+	}
+
+	netmask := 0
+	if ip.To4() != nil {
+		netmask = 32
+	} else if ip.To16() != nil {
+		netmask = 128
+	} else {
+		klog.Warningf("skipping %q: unable to detmine if IPv4 or IPv6 address", address)
+		return "", false
+	}
+
+	return fmt.Sprintf("%s/%d", ip.String(), netmask), true
+}
+
+// apiServerEndpointSubsets converts the Endpoints of the Kubernetes API server
+// into the addresses and ports made available to the policy templates.
+func apiServerEndpointSubsets(apiServerEndpoints *corev1.Endpoints) []templates.EndpointSubset {
+	subsets := []templates.EndpointSubset{}
+
+	for _, subset := range apiServerEndpoints.Subsets {
+		endpointSubset := templates.EndpointSubset{
+			CIDRs: []string{},
+			Ports: []templates.EndpointPort{},
+		}
+
+		for _, address := range subset.Addresses {
+			if cidr, ok := hostCIDR(address.IP); ok {
+				endpointSubset.CIDRs = append(endpointSubset.CIDRs, cidr)
+			}
+		}
+
+		for _, port := range subset.Ports {
+			endpointSubset.Ports = append(endpointSubset.Ports, templates.EndpointPort{
+				Protocol: port.Protocol,
+				Port:     port.Port,
+			})
+		}
+
+		subsets = append(subsets, endpointSubset)
+	}
+
+	return subsets
+}
+
+// toEndpointSlice converts an unstructured discovery.k8s.io/v1 or v1beta1
+// EndpointSlice. The fields read by the controller are identical in v1beta1
+// and v1, and fields only present in v1 are ignored by the conversion.
+func toEndpointSlice(obj interface{}) (*discoveryv1beta1.EndpointSlice, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("expected *unstructured.Unstructured but got %T", obj)
+	}
+
+	slice := &discoveryv1beta1.EndpointSlice{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), slice); err != nil {
+		return nil, fmt.Errorf("failed to convert EndpointSlice %s/%s: %v", u.GetNamespace(), u.GetName(), err)
+	}
+
+	return slice, nil
+}
+
+// apiServerEndpointSliceSubsets merges the EndpointSlices of the Kubernetes
+// API server (including the IPv4 and IPv6 slices of dual-stack clusters)
+// into the addresses and ports made available to the policy templates.
+func apiServerEndpointSliceSubsets(slices []*discoveryv1beta1.EndpointSlice) []templates.EndpointSubset {
+	subsets := []templates.EndpointSubset{}
+
+	// Sort the slices so the generated rules are stable
+	sort.Slice(slices, func(i, j int) bool {
+		return slices[i].Name < slices[j].Name
+	})
+
+	for _, slice := range slices {
+		if slice.AddressType != discoveryv1beta1.AddressTypeIPv4 && slice.AddressType != discoveryv1beta1.AddressTypeIPv6 {
+			continue
+		}
+
+		endpointSubset := templates.EndpointSubset{
+			CIDRs: []string{},
+			Ports: []templates.EndpointPort{},
+		}
+
+		for _, endpoint := range slice.Endpoints {
+			// A nil ready condition is interpreted as ready
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+
+			for _, address := range endpoint.Addresses {
+				if cidr, ok := hostCIDR(address); ok {
+					endpointSubset.CIDRs = append(endpointSubset.CIDRs, cidr)
+				}
+			}
+		}
+
+		for _, port := range slice.Ports {
+			if port.Port == nil {
+				continue
+			}
+
+			protocol := corev1.ProtocolTCP
+			if port.Protocol != nil {
+				protocol = *port.Protocol
+			}
+
+			endpointSubset.Ports = append(endpointSubset.Ports, templates.EndpointPort{
+				Protocol: protocol,
+				Port:     *port.Port,
+			})
+		}
+
+		if len(endpointSubset.CIDRs) == 0 {
+			continue
+		}
+
+		subsets = append(subsets, endpointSubset)
+	}
+
+	return subsets
+}
+
+// apiServerAddresses returns the addresses of the Kubernetes API server.
+// EndpointSlices are preferred, and the Endpoints of the `kubernetes` service
+// are used when slices are unavailable (sliceLister is nil) or none exist.
+func apiServerAddresses(sliceLister cache.GenericLister, endpointsLister corev1listers.EndpointsLister) ([]templates.EndpointSubset, error) {
+	if sliceLister != nil {
+		objs, err := sliceLister.ByNamespace("default").List(kubernetesServiceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list endpoint slices of Kubernetes API server: %v", err)
+		}
+
+		slices := []*discoveryv1beta1.EndpointSlice{}
+		for _, obj := range objs {
+			slice, err := toEndpointSlice(obj)
+			if err != nil {
+				return nil, err
+			}
+			slices = append(slices, slice)
+		}
+
+		if len(slices) > 0 {
+			return apiServerEndpointSliceSubsets(slices), nil
+		}
+
+		klog.V(4).Info("no endpoint slices found for the Kubernetes API server; falling back to endpoints")
+	}
+
+	apiServerEndpoints, err := endpointsLister.Endpoints("default").Get("kubernetes")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list endpoints of Kubernetes API server: %v", err)
+	}
+
+	return apiServerEndpointSubsets(apiServerEndpoints), nil
+}
+
+// apiServerEndpointsEventHandlers triggers the debouncer when the addresses
+// of the Endpoints of the Kubernetes API server change.
+func apiServerEndpointsEventHandlers(debouncer *debouncer) cache.ResourceEventHandler {
+	return cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			endpoints, ok := obj.(*corev1.Endpoints)
+			return ok && endpoints.Name == "kubernetes"
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				debouncer.Trigger()
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				old := oldObj.(*corev1.Endpoints)
+				new := newObj.(*corev1.Endpoints)
+
+				// Only the addresses and ports affect the generated policies
+				if reflect.DeepEqual(apiServerEndpointSubsets(old), apiServerEndpointSubsets(new)) {
+					return
+				}
+
+				debouncer.Trigger()
+			},
+			DeleteFunc: func(obj interface{}) {
+				debouncer.Trigger()
+			},
+		},
+	}
+}
+
+// apiServerEndpointSliceEventHandlers triggers the debouncer when the
+// addresses of the EndpointSlices of the Kubernetes API server change.
+func apiServerEndpointSliceEventHandlers(debouncer *debouncer) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			debouncer.Trigger()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			old, err := toEndpointSlice(oldObj)
+			if err != nil {
+				klog.Error(err)
+				return
+			}
+			new, err := toEndpointSlice(newObj)
+			if err != nil {
+				klog.Error(err)
+				return
+			}
+
+			// Only the addresses and ports affect the generated policies
+			if reflect.DeepEqual(apiServerEndpointSliceSubsets([]*discoveryv1beta1.EndpointSlice{old}), apiServerEndpointSliceSubsets([]*discoveryv1beta1.EndpointSlice{new})) {
+				return
+			}
+
+			debouncer.Trigger()
+		},
+		DeleteFunc: func(obj interface{}) {
+			debouncer.Trigger()
+		},
+	}
+}
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/StatCan/namespace-controller/pkg/controllers/namespaces"
+	"github.com/StatCan/namespace-controller/pkg/network/templates"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAPIServerEndpointSliceSubsets(t *testing.T) {
+	ready, notReady := true, false
+	port, protocol := int32(6443), corev1.ProtocolUDP
+
+	slices := []*discoveryv1beta1.EndpointSlice{
+		{
+			ObjectMeta:  metav1.ObjectMeta{Name: "kubernetes-b"},
+			AddressType: discoveryv1beta1.AddressTypeIPv6,
+			Endpoints: []discoveryv1beta1.Endpoint{
+				{Addresses: []string{"fd00::1"}},
+			},
+			Ports: []discoveryv1beta1.EndpointPort{{Port: &port, Protocol: &protocol}},
+		},
+		{
+			ObjectMeta:  metav1.ObjectMeta{Name: "kubernetes-a"},
+			AddressType: discoveryv1beta1.AddressTypeIPv4,
+			Endpoints: []discoveryv1beta1.Endpoint{
+				{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1beta1.EndpointConditions{Ready: &ready}},
+				{Addresses: []string{"10.0.0.2"}, Conditions: discoveryv1beta1.EndpointConditions{Ready: &notReady}},
+				// A nil ready condition is interpreted as ready
+				{Addresses: []string{"10.0.0.3"}},
+			},
+			// Ports without a number are skipped, and the protocol defaults to TCP
+			Ports: []discoveryv1beta1.EndpointPort{{Port: &port}, {}},
+		},
+		{
+			// Slices without ready endpoints are skipped
+			ObjectMeta:  metav1.ObjectMeta{Name: "kubernetes-c"},
+			AddressType: discoveryv1beta1.AddressTypeIPv4,
+			Endpoints: []discoveryv1beta1.Endpoint{
+				{Addresses: []string{"10.0.0.4"}, Conditions: discoveryv1beta1.EndpointConditions{Ready: &notReady}},
+			},
+			Ports: []discoveryv1beta1.EndpointPort{{Port: &port}},
+		},
+		{
+			// FQDN slices are skipped
+			ObjectMeta:  metav1.ObjectMeta{Name: "kubernetes-d"},
+			AddressType: discoveryv1beta1.AddressTypeFQDN,
+			Endpoints: []discoveryv1beta1.Endpoint{
+				{Addresses: []string{"kubernetes.example.com"}},
+			},
+			Ports: []discoveryv1beta1.EndpointPort{{Port: &port}},
+		},
+	}
+
+	expected := []templates.EndpointSubset{
+		{
+			CIDRs: []string{"10.0.0.1/32", "10.0.0.3/32"},
+			Ports: []templates.EndpointPort{{Protocol: corev1.ProtocolTCP, Port: 6443}},
+		},
+		{
+			CIDRs: []string{"fd00::1/128"},
+			Ports: []templates.EndpointPort{{Protocol: corev1.ProtocolUDP, Port: 6443}},
+		},
+	}
+
+	if subsets := apiServerEndpointSliceSubsets(slices); !reflect.DeepEqual(subsets, expected) {
+		t.Errorf("expected %+v, got %+v", expected, subsets)
+	}
+}
+
+func TestServedEndpointSlicesResource(t *testing.T) {
+	endpointSlices := []metav1.APIResource{{Name: "endpointslices", Namespaced: true, Kind: "EndpointSlice"}}
+
+	tests := []struct {
+		name      string
+		resources []*metav1.APIResourceList
+		expected  string
+	}{
+		{
+			name: "v1",
+			resources: []*metav1.APIResourceList{
+				{GroupVersion: "discovery.k8s.io/v1", APIResources: endpointSlices},
+				{GroupVersion: "discovery.k8s.io/v1beta1", APIResources: endpointSlices},
+			},
+			expected: "v1",
+		},
+		{
+			name: "v1beta1",
+			resources: []*metav1.APIResourceList{
+				{GroupVersion: "discovery.k8s.io/v1beta1", APIResources: endpointSlices},
+			},
+			expected: "v1beta1",
+		},
+		{
+			name: "unavailable",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			kubeClient.Discovery().(*fakediscovery.FakeDiscovery).Resources = test.resources
+
+			resource, err := servedEndpointSlicesResource(kubeClient.Discovery())
+			if test.expected == "" {
+				if err == nil {
+					t.Errorf("expected endpoint slices to be unavailable, got %s", resource)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("failed to discover endpoint slices: %v", err)
+			}
+			if resource.Version != test.expected {
+				t.Errorf("expected version %s, got %s", test.expected, resource.Version)
+			}
+		})
+	}
+}
+
+func newAPIServerEndpoints(name, ip string) *corev1.Endpoints {
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", ResourceVersion: ip},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{{IP: ip}},
+			Ports:     []corev1.EndpointPort{{Protocol: corev1.ProtocolTCP, Port: 6443}},
+		}},
+	}
+}
+
+func newAPIServerEndpointSlice(t *testing.T, ip string) *unstructured.Unstructured {
+	port := int32(6443)
+	slice := &discoveryv1beta1.EndpointSlice{
+		TypeMeta:    metav1.TypeMeta{APIVersion: "discovery.k8s.io/v1", Kind: "EndpointSlice"},
+		ObjectMeta:  metav1.ObjectMeta{Name: "kubernetes", Namespace: "default", ResourceVersion: ip},
+		AddressType: discoveryv1beta1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1beta1.Endpoint{{Addresses: []string{ip}}},
+		Ports:       []discoveryv1beta1.EndpointPort{{Port: &port}},
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(slice)
+	if err != nil {
+		t.Fatalf("failed to convert endpoint slice: %v", err)
+	}
+
+	return &unstructured.Unstructured{Object: content}
+}
+
+func TestAPIServerEndpointsEventHandlers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kubeClient := fake.NewSimpleClientset(newTestNamespace("alpha", nil), newTestNamespace("beta", nil))
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, 0)
+
+	synced := make(chan string, 10)
+	controller := namespaces.NewController("test", kubeInformerFactory.Core().V1().Namespaces(), func(ctx context.Context, namespace *corev1.Namespace) error {
+		synced <- namespace.Name
+		return nil
+	})
+	kubeInformerFactory.Start(ctx.Done())
+	go func() {
+		if err := controller.Run(ctx); err != nil {
+			t.Errorf("failed to run controller: %v", err)
+		}
+	}()
+
+	// expectSynced waits for the namespaces to be synced, and ensures no
+	// other namespace is synced
+	expectSynced := func(expected ...string) {
+		t.Helper()
+
+		names := []string{}
+		timeout := time.After(time.Second)
+		for len(names) < len(expected) {
+			select {
+			case name := <-synced:
+				names = append(names, name)
+			case <-timeout:
+				t.Fatalf("expected namespaces %v to be synced, got %v", expected, names)
+			}
+		}
+
+		select {
+		case name := <-synced:
+			t.Fatalf("expected namespaces %v to be synced, got %v", expected, append(names, name))
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		sort.Strings(names)
+		if len(names) > 0 && !reflect.DeepEqual(names, expected) {
+			t.Fatalf("expected namespaces %v to be synced, got %v", expected, names)
+		}
+	}
+
+	// The namespaces are synced when the controller starts
+	expectSynced("alpha", "beta")
+
+	d := newDebouncer(10*time.Millisecond, controller.EnqueueAllNamespaces)
+	endpointsHandler := apiServerEndpointsEventHandlers(d)
+	sliceHandler := apiServerEndpointSliceEventHandlers(d)
+
+	// Changes which do not affect the addresses of the API server are ignored
+	endpointsHandler.OnUpdate(newAPIServerEndpoints("kubernetes", "10.0.0.1"), newAPIServerEndpoints("kubernetes", "10.0.0.1"))
+	endpointsHandler.OnUpdate(newAPIServerEndpoints("other", "10.0.0.1"), newAPIServerEndpoints("other", "10.0.0.2"))
+	sliceHandler.OnUpdate(newAPIServerEndpointSlice(t, "10.0.0.1"), newAPIServerEndpointSlice(t, "10.0.0.1"))
+	expectSynced()
+
+	// A change of the addresses re-syncs every namespace
+	endpointsHandler.OnUpdate(newAPIServerEndpoints("kubernetes", "10.0.0.1"), newAPIServerEndpoints("kubernetes", "10.0.0.2"))
+	expectSynced("alpha", "beta")
+
+	sliceHandler.OnUpdate(newAPIServerEndpointSlice(t, "10.0.0.1"), newAPIServerEndpointSlice(t, "10.0.0.2"))
+	expectSynced("alpha", "beta")
+
+	sliceHandler.OnDelete(newAPIServerEndpointSlice(t, "10.0.0.2"))
+	expectSynced("alpha", "beta")
+}
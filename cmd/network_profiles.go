@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	networkv1alpha1 "github.com/StatCan/namespace-controller/pkg/apis/network/v1alpha1"
+	"github.com/StatCan/namespace-controller/pkg/controllers/namespaces"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+// getNetworkProfile loads a NetworkProfile from the dynamic lister and
+// converts it to its typed representation.
+func getNetworkProfile(lister cache.GenericLister, name string) (*networkv1alpha1.NetworkProfile, error) {
+	obj, err := lister.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return toNetworkProfile(obj)
+}
+
+// selectNetworkProfile loads the profile selected by the namespace. When the
+// profile does not exist, a warning is recorded and nil is returned, so that
+// the policies of the namespace are generated from its labels until the
+// profile is created. The recorder may be nil.
+func selectNetworkProfile(lister cache.GenericLister, namespace *corev1.Namespace, name string, recorder record.EventRecorder) (*networkv1alpha1.NetworkProfile, error) {
+	profile, err := getNetworkProfile(lister, name)
+	if errors.IsNotFound(err) {
+		klog.Warningf("namespace <%s> selects network profile %q which does not exist; using labels", namespace.Name, name)
+		if recorder != nil {
+			recorder.Eventf(namespace, corev1.EventTypeWarning, "NetworkProfileNotFound", "Network profile %s does not exist; using the network.statcan.gc.ca labels", name)
+		}
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load network profile %q: %v", name, err)
+	}
+
+	return profile, nil
+}
+
+func toNetworkProfile(obj interface{}) (*networkv1alpha1.NetworkProfile, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("expected *unstructured.Unstructured but got %T", obj)
+	}
+
+	profile := &networkv1alpha1.NetworkProfile{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), profile); err != nil {
+		return nil, fmt.Errorf("failed to convert NetworkProfile %s: %v", u.GetName(), err)
+	}
+
+	return profile, nil
+}
+
+// networkProfileEventHandlers queues the namespaces selecting a profile
+// whenever the profile changes.
+func networkProfileEventHandlers(controller *namespaces.Controller, namespaceLister corev1listers.NamespaceLister) cache.ResourceEventHandlerFuncs {
+	enqueue := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return
+		}
+
+		selector := labels.SelectorFromSet(labels.Set{networkv1alpha1.ProfileLabel: u.GetName()})
+		namespaces, err := namespaceLister.List(selector)
+		if err != nil {
+			klog.Errorf("failed listing namespaces for network profile %s: %v", u.GetName(), err)
+			return
+		}
+
+		for _, namespace := range namespaces {
+			klog.Infof("queuing namespace <%s> for processing due to update to NetworkProfile/%s", namespace.Name, u.GetName())
+			controller.EnqueueNamespace(namespace)
+		}
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(old, new interface{}) {
+			enqueue(new)
+		},
+		DeleteFunc: enqueue,
+	}
+}
+
+// updateNetworkProfileStatuses records in the status of each NetworkProfile
+// the namespaces which select it.
+func updateNetworkProfileStatuses(ctx context.Context, dynamicClient dynamic.Interface, profileLister cache.GenericLister, namespaceLister corev1listers.NamespaceLister) {
+	usage := map[string][]string{}
+
+	namespaces, err := namespaceLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to list namespaces: %v", err)
+		return
+	}
+
+	for _, namespace := range namespaces {
+		if name, ok := namespace.Labels[networkv1alpha1.ProfileLabel]; ok {
+			usage[name] = append(usage[name], namespace.Name)
+		}
+	}
+
+	objs, err := profileLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to list network profiles: %v", err)
+		return
+	}
+
+	for _, obj := range objs {
+		profile, err := toNetworkProfile(obj)
+		if err != nil {
+			klog.Error(err)
+			continue
+		}
+
+		status := networkv1alpha1.NetworkProfileStatus{
+			Namespaces:     usage[profile.Name],
+			NamespaceCount: len(usage[profile.Name]),
+		}
+		sort.Strings(status.Namespaces)
+
+		if reflect.DeepEqual(profile.Status, status) {
+			continue
+		}
+
+		profile.Status = status
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(profile)
+		if err != nil {
+			klog.Errorf("failed to convert NetworkProfile %s: %v", profile.Name, err)
+			continue
+		}
+
+		klog.Infof("updating status of network profile %s (%d namespaces)", profile.Name, status.NamespaceCount)
+		_, err = dynamicClient.Resource(networkv1alpha1.NetworkProfilesResource).UpdateStatus(ctx, &unstructured.Unstructured{Object: content}, metav1.UpdateOptions{})
+		if err != nil {
+			klog.Errorf("failed to update status of network profile %s: %v", profile.Name, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	networkv1alpha1 "github.com/StatCan/namespace-controller/pkg/apis/network/v1alpha1"
+	"github.com/StatCan/namespace-controller/pkg/network/config"
+	"github.com/StatCan/namespace-controller/pkg/network/templates"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+var renderNamespacePath string
+var renderEndpointsPath string
+var renderProfilePath string
+
+var networkRenderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Render the network policies of a namespace without a cluster connection.",
+	Long: `Render the network policies of a namespace without a cluster connection.
+
+The Namespace manifest is read from --filename, or from stdin by default.
+The Endpoints of the Kubernetes API server (kubectl get endpoints kubernetes -n default -o yaml)
+are read from --endpoints; without them, the policies allow no access to the API server.
+
+The policies are printed as YAML, using the same templates and configuration
+as the controller (--policy-templates-dir and --config).
+	`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := renderNetworkPolicies(cmd.OutOrStdout()); err != nil {
+			klog.Fatalf("error rendering network policies: %v", err)
+		}
+	},
+}
+
+// renderNetworkPolicies renders the policies of the namespace manifest
+// and writes them to out as a YAML stream.
+func renderNetworkPolicies(out io.Writer) error {
+	var err error
+
+	networkConfig := config.Default()
+	if networkConfigPath != "" {
+		networkConfig, err = config.Load(networkConfigPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	templateSet := templates.Default()
+	if policyTemplatesDir != "" {
+		templateSet, err = templates.LoadDirectory(policyTemplatesDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if renderNamespacePath == "-" && (renderEndpointsPath == "-" || renderProfilePath == "-") {
+		return fmt.Errorf("only one manifest can be read from stdin")
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := decodeManifest(renderNamespacePath, namespace); err != nil {
+		return err
+	}
+	if namespace.Name == "" {
+		return fmt.Errorf("the namespace manifest %q has no name", renderNamespacePath)
+	}
+
+	apiServer := []templates.EndpointSubset{}
+	if renderEndpointsPath != "" {
+		endpoints := &corev1.Endpoints{}
+		if err := decodeManifest(renderEndpointsPath, endpoints); err != nil {
+			return err
+		}
+		apiServer = apiServerEndpointSubsets(endpoints)
+	} else {
+		klog.Warning("no endpoints provided with --endpoints; the Kubernetes API server will not be reachable")
+	}
+
+	var profile *networkv1alpha1.NetworkProfile
+	if renderProfilePath != "" {
+		profile = &networkv1alpha1.NetworkProfile{}
+		if err := decodeManifest(renderProfilePath, profile); err != nil {
+			return err
+		}
+	}
+
+	policies, err := generateNetworkPolicies(templateSet, networkConfig, namespace, profile, apiServer)
+	if err != nil {
+		return err
+	}
+
+	for i, policy := range policies {
+		policy.APIVersion = "networking.k8s.io/v1"
+		policy.Kind = "NetworkPolicy"
+
+		b, err := yaml.Marshal(policy)
+		if err != nil {
+			return fmt.Errorf("failed to serialize network policy %s: %v", policy.Name, err)
+		}
+
+		if i > 0 {
+			fmt.Fprintln(out, "---")
+		}
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeManifest decodes the YAML or JSON manifest at path into obj.
+// The manifest is read from stdin when path is "-".
+func decodeManifest(path string, obj interface{}) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open manifest: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	if err := utilyaml.NewYAMLOrJSONDecoder(r, 4096).Decode(obj); err != nil {
+		return fmt.Errorf("failed to decode manifest %q: %v", path, err)
+	}
+
+	return nil
+}
+
+func init() {
+	networkRenderCmd.Flags().StringVarP(&renderNamespacePath, "filename", "f", "-", "Path to the Namespace manifest, or - for stdin")
+	networkRenderCmd.Flags().StringVar(&renderEndpointsPath, "endpoints", "", "Path to the Endpoints manifest of the Kubernetes API server (the kubernetes service in the default namespace)")
+	networkRenderCmd.Flags().StringVar(&renderProfilePath, "profile", "", "Path to the NetworkProfile manifest selected by the namespace, if any")
+
+	networkCmd.AddCommand(networkRenderCmd)
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const renderNamespace = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: alpha
+  labels:
+    network.statcan.gc.ca/profile: test
+`
+
+const renderEndpoints = `apiVersion: v1
+kind: Endpoints
+metadata:
+  name: kubernetes
+  namespace: default
+subsets:
+- addresses:
+  - ip: 10.0.0.1
+  ports:
+  - name: https
+    port: 6443
+    protocol: TCP
+`
+
+const renderProfile = `apiVersion: network.statcan.gc.ca/v1alpha1
+kind: NetworkProfile
+metadata:
+  name: test
+spec:
+  allowSameNamespace: true
+`
+
+// setRenderFlags writes the manifests to a temporary directory and points
+// the flags of the render command to them. The returned function resets
+// the flags.
+func setRenderFlags(t *testing.T, manifests map[string]string) func() {
+	dir := t.TempDir()
+	paths := map[string]string{}
+	for name, contents := range manifests {
+		paths[name] = filepath.Join(dir, name+".yaml")
+		if err := ioutil.WriteFile(paths[name], []byte(contents), 0644); err != nil {
+			t.Fatalf("failed to write manifest: %v", err)
+		}
+	}
+
+	renderNamespacePath = paths["namespace"]
+	renderEndpointsPath = paths["endpoints"]
+	renderProfilePath = paths["profile"]
+
+	return func() {
+		renderNamespacePath = "-"
+		renderEndpointsPath = ""
+		renderProfilePath = ""
+	}
+}
+
+func TestRenderNetworkPolicies(t *testing.T) {
+	defer setRenderFlags(t, map[string]string{
+		"namespace": renderNamespace,
+		"endpoints": renderEndpoints,
+		"profile":   renderProfile,
+	})()
+
+	out := &bytes.Buffer{}
+	if err := renderNetworkPolicies(out); err != nil {
+		t.Fatalf("failed to render network policies: %v", err)
+	}
+
+	names := []string{}
+	for _, document := range strings.Split(out.String(), "\n---\n") {
+		policy := &networkingv1.NetworkPolicy{}
+		if err := yaml.Unmarshal([]byte(document), policy); err != nil {
+			t.Fatalf("failed to decode network policy: %v\n%s", err, document)
+		}
+
+		if policy.APIVersion != "networking.k8s.io/v1" || policy.Kind != "NetworkPolicy" {
+			t.Errorf("expected a networking.k8s.io/v1 NetworkPolicy, got %s %s", policy.APIVersion, policy.Kind)
+		}
+		if policy.Namespace != "alpha" {
+			t.Errorf("expected network policy %s to be in namespace alpha, got %q", policy.Name, policy.Namespace)
+		}
+
+		if policy.Name == "allow-kube-apiserver" {
+			if cidr := policy.Spec.Egress[0].To[0].IPBlock.CIDR; cidr != "10.0.0.1/32" {
+				t.Errorf("expected the API server to be allowed from the endpoints, got %s", cidr)
+			}
+		}
+
+		names = append(names, policy.Name)
+	}
+
+	// The profile allows the traffic within the namespace
+	expected := []string{"default-deny", "allow-same-namespace", "allow-core-system", "allow-kube-apiserver"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected policies %v, got %v", expected, names)
+	}
+}
+
+func TestRenderNetworkPoliciesErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		manifests map[string]string
+		stdin     []string
+		expected  string
+	}{
+		{
+			name:      "endpoints from stdin",
+			manifests: map[string]string{"namespace": renderNamespace},
+			stdin:     []string{"namespace", "endpoints"},
+			expected:  "only one manifest can be read from stdin",
+		},
+		{
+			name:      "profile from stdin",
+			manifests: map[string]string{"namespace": renderNamespace},
+			stdin:     []string{"namespace", "profile"},
+			expected:  "only one manifest can be read from stdin",
+		},
+		{
+			name:      "namespace without a name",
+			manifests: map[string]string{"namespace": "apiVersion: v1\nkind: Namespace\n"},
+			expected:  "has no name",
+		},
+		{
+			name:      "invalid endpoints",
+			manifests: map[string]string{"namespace": renderNamespace, "endpoints": "subsets: invalid\n"},
+			expected:  "failed to decode manifest",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer setRenderFlags(t, test.manifests)()
+			for _, name := range test.stdin {
+				switch name {
+				case "namespace":
+					renderNamespacePath = "-"
+				case "endpoints":
+					renderEndpointsPath = "-"
+				case "profile":
+					renderProfilePath = "-"
+				}
+			}
+
+			err := renderNetworkPolicies(&bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("expected error %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package cmd
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	networkv1alpha1 "github.com/StatCan/namespace-controller/pkg/apis/network/v1alpha1"
+	"github.com/StatCan/namespace-controller/pkg/network/config"
+	"github.com/StatCan/namespace-controller/pkg/network/templates"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/yaml"
+)
+
+// testAPIServer is the address of the Kubernetes API server used by the tests.
+var testAPIServer = []templates.EndpointSubset{
+	{
+		CIDRs: []string{"10.0.0.1/32"},
+		Ports: []templates.EndpointPort{{Protocol: corev1.ProtocolTCP, Port: 443}},
+	},
+}
+
+func newTestNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+			UID:    "test-uid",
+		},
+	}
+}
+
+// renderDefaultPolicies renders the default templates for the namespace,
+// returning the policies by name.
+func renderDefaultPolicies(t *testing.T, namespace *corev1.Namespace) map[string]*networkingv1.NetworkPolicy {
+	policies, err := generateNetworkPolicies(templates.Default(), config.Default(), namespace, nil, testAPIServer)
+	if err != nil {
+		t.Fatalf("failed to generate network policies: %v", err)
+	}
+
+	byName := map[string]*networkingv1.NetworkPolicy{}
+	for _, policy := range policies {
+		byName[policy.Name] = policy
+	}
+
+	return byName
+}
+
+// assertYAML checks that the value is equal to the expected YAML document,
+// comparing their JSON representation.
+func assertYAML(t *testing.T, value interface{}, expected string) {
+	t.Helper()
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("failed to serialize value: %v", err)
+	}
+
+	var got, want interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("failed to deserialize value: %v", err)
+	}
+	if err := yaml.Unmarshal([]byte(expected), &want); err != nil {
+		t.Fatalf("failed to parse expected value: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		gotYAML, _ := yaml.Marshal(got)
+		wantYAML, _ := yaml.Marshal(want)
+		t.Errorf("expected:\n%s\ngot:\n%s", wantYAML, gotYAML)
+	}
+}
+
+// policyNames returns the names of the policies, in order.
+func policyNames(policies []*networkingv1.NetworkPolicy) []string {
+	names := []string{}
+	for _, policy := range policies {
+		names = append(names, policy.Name)
+	}
+
+	return names
+}
+
+func TestGenerateNetworkPoliciesLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected []string
+
+		// systemAPIServer is true when every pod may reach the API server
+		systemAPIServer bool
+	}{
+		{
+			name:     "default",
+			expected: []string{"default-deny", "allow-core-system", "allow-kube-apiserver"},
+		},
+		{
+			name: "allow labels",
+			labels: map[string]string{
+				"network.statcan.gc.ca/allow-same-ns":            "true",
+				"network.statcan.gc.ca/allow-ingress-controller": "true",
+			},
+			expected: []string{"default-deny", "allow-same-namespace", "allow-ingress-controller", "allow-core-system", "allow-kube-apiserver"},
+		},
+		{
+			name: "invalid allow labels",
+			labels: map[string]string{
+				"network.statcan.gc.ca/allow-same-ns":            "yes",
+				"network.statcan.gc.ca/allow-ingress-controller": "yes",
+			},
+			expected: []string{"default-deny", "allow-core-system", "allow-kube-apiserver"},
+		},
+		{
+			name:            "system",
+			labels:          map[string]string{"namespace.statcan.gc.ca/purpose": "system"},
+			expected:        []string{"default-deny", "allow-same-namespace", "allow-core-system", "allow-kube-apiserver"},
+			systemAPIServer: true,
+		},
+		{
+			name:            "daaas",
+			labels:          map[string]string{"namespace.statcan.gc.ca/purpose": "daaas"},
+			expected:        []string{"default-deny", "allow-same-namespace", "allow-core-system", "allow-kube-apiserver"},
+			systemAPIServer: true,
+		},
+		{
+			name: "system without same namespace",
+			labels: map[string]string{
+				"namespace.statcan.gc.ca/purpose":     "system",
+				"network.statcan.gc.ca/allow-same-ns": "false",
+			},
+			expected:        []string{"default-deny", "allow-core-system", "allow-kube-apiserver"},
+			systemAPIServer: true,
+		},
+		{
+			// Invalid values do not allow traffic, even in system namespaces
+			name: "system with invalid same namespace",
+			labels: map[string]string{
+				"namespace.statcan.gc.ca/purpose":     "system",
+				"network.statcan.gc.ca/allow-same-ns": "yes",
+			},
+			expected:        []string{"default-deny", "allow-core-system", "allow-kube-apiserver"},
+			systemAPIServer: true,
+		},
+		{
+			name:     "other purpose",
+			labels:   map[string]string{"namespace.statcan.gc.ca/purpose": "user"},
+			expected: []string{"default-deny", "allow-core-system", "allow-kube-apiserver"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			namespace := newTestNamespace("alpha", test.labels)
+			policies, err := generateNetworkPolicies(templates.Default(), config.Default(), namespace, nil, testAPIServer)
+			if err != nil {
+				t.Fatalf("failed to generate network policies: %v", err)
+			}
+
+			if names := policyNames(policies); !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("expected policies %v, got %v", test.expected, names)
+			}
+
+			for _, policy := range policies {
+				if policy.Namespace != "alpha" {
+					t.Errorf("expected policy %s in namespace alpha, got %q", policy.Name, policy.Namespace)
+				}
+				if policy.Labels[managedByLabel] != managedByValue {
+					t.Errorf("expected policy %s to have the managed-by label, got %v", policy.Name, policy.Labels)
+				}
+				if !isManagedObject(namespace, policy) || metav1.GetControllerOf(policy) == nil {
+					t.Errorf("expected policy %s to be controlled by the namespace", policy.Name)
+				}
+
+				if policy.Name == "allow-kube-apiserver" {
+					all := len(policy.Spec.PodSelector.MatchLabels) == 0
+					if all != test.systemAPIServer {
+						t.Errorf("expected the API server to be allowed for all pods to be %v, got pod selector %v", test.systemAPIServer, policy.Spec.PodSelector)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateNetworkPoliciesProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     networkv1alpha1.NetworkProfileSpec
+		expected []string
+	}{
+		{
+			// The profile replaces the labels of the namespace
+			name:     "without allow",
+			expected: []string{"default-deny", "allow-core-system", "allow-kube-apiserver"},
+		},
+		{
+			name: "allow",
+			spec: networkv1alpha1.NetworkProfileSpec{
+				AllowSameNamespace:     true,
+				AllowIngressController: true,
+			},
+			expected: []string{"default-deny", "allow-same-namespace", "allow-ingress-controller", "allow-core-system", "allow-kube-apiserver"},
+		},
+		{
+			// Templates are rendered in the order of the profile,
+			// and may omit their extension
+			name: "templates",
+			spec: networkv1alpha1.NetworkProfileSpec{
+				AllowSameNamespace: true,
+				Templates:          []string{"default-deny", "allow-same-namespace.yaml"},
+			},
+			expected: []string{"default-deny", "allow-same-namespace"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			namespace := newTestNamespace("alpha", map[string]string{
+				networkv1alpha1.ProfileLabel:                     "test",
+				"network.statcan.gc.ca/allow-same-ns":            "true",
+				"network.statcan.gc.ca/allow-ingress-controller": "true",
+			})
+			profile := &networkv1alpha1.NetworkProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Spec:       test.spec,
+			}
+
+			policies, err := generateNetworkPolicies(templates.Default(), config.Default(), namespace, profile, testAPIServer)
+			if err != nil {
+				t.Fatalf("failed to generate network policies: %v", err)
+			}
+
+			if names := policyNames(policies); !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("expected policies %v, got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestGenerateNetworkPoliciesUnknownProfileTemplate(t *testing.T) {
+	profile := &networkv1alpha1.NetworkProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Spec:       networkv1alpha1.NetworkProfileSpec{Templates: []string{"missing"}},
+	}
+
+	_, err := generateNetworkPolicies(templates.Default(), config.Default(), newTestNamespace("alpha", nil), profile, testAPIServer)
+	if err == nil {
+		t.Fatalf("expected an error for a missing template")
+	}
+}
+
+func TestGenerateNetworkPoliciesAPIServer(t *testing.T) {
+	// A dual-stack API server exposes each address family as a subset
+	apiServer := []templates.EndpointSubset{
+		{
+			CIDRs: []string{"10.0.0.1/32", "10.0.0.2/32"},
+			Ports: []templates.EndpointPort{{Protocol: corev1.ProtocolTCP, Port: 443}},
+		},
+		{
+			CIDRs: []string{"fd00::1/128"},
+			Ports: []templates.EndpointPort{{Protocol: corev1.ProtocolTCP, Port: 6443}},
+		},
+	}
+
+	policies, err := generateNetworkPolicies(templates.Default(), config.Default(), newTestNamespace("alpha", nil), nil, apiServer)
+	if err != nil {
+		t.Fatalf("failed to generate network policies: %v", err)
+	}
+
+	var policy *networkingv1.NetworkPolicy
+	for _, p := range policies {
+		if p.Name == "allow-kube-apiserver" {
+			policy = p
+		}
+	}
+	if policy == nil {
+		t.Fatalf("expected the allow-kube-apiserver policy, got %v", policyNames(policies))
+	}
+
+	assertYAML(t, policy.Spec, `
+podSelector:
+  matchLabels:
+    network.statcan.gc.ca/allow-kube-apiserver: "true"
+policyTypes: [Egress]
+egress:
+- to:
+  - ipBlock:
+      cidr: 10.0.0.1/32
+  - ipBlock:
+      cidr: 10.0.0.2/32
+  ports:
+  - protocol: TCP
+    port: 443
+- to:
+  - ipBlock:
+      cidr: fd00::1/128
+  ports:
+  - protocol: TCP
+    port: 6443
+`)
+}
+
+func TestSelectNetworkProfile(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := indexer.Add(&unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "network.statcan.gc.ca/v1alpha1",
+		"kind":       "NetworkProfile",
+		"metadata":   map[string]interface{}{"name": "restricted"},
+		"spec":       map[string]interface{}{"allowSameNamespace": true},
+	}}); err != nil {
+		t.Fatalf("failed to index profile: %v", err)
+	}
+	lister := cache.NewGenericLister(indexer, networkv1alpha1.NetworkProfilesResource.GroupResource())
+	namespace := newTestNamespace("alpha", nil)
+
+	recorder := record.NewFakeRecorder(10)
+	profile, err := selectNetworkProfile(lister, namespace, "restricted", recorder)
+	if err != nil {
+		t.Fatalf("failed to select profile: %v", err)
+	}
+	if profile == nil || !profile.Spec.AllowSameNamespace {
+		t.Errorf("expected the restricted profile, got %+v", profile)
+	}
+
+	// A missing profile falls back to the labels with a warning
+	profile, err = selectNetworkProfile(lister, namespace, "missing", recorder)
+	if err != nil {
+		t.Fatalf("expected a missing profile not to fail, got %v", err)
+	}
+	if profile != nil {
+		t.Errorf("expected no profile, got %+v", profile)
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning NetworkProfileNotFound") {
+			t.Errorf("expected a NetworkProfileNotFound warning, got %q", event)
+		}
+	default:
+		t.Errorf("expected a warning for the missing profile")
+	}
+
+	// Warnings are optional
+	if _, err := selectNetworkProfile(lister, namespace, "missing", nil); err != nil {
+		t.Errorf("expected a missing profile not to fail without a recorder, got %v", err)
+	}
+}
@@ -1,11 +1,24 @@
 package cmd
 
 import (
+	"time"
+
 	"github.com/spf13/cobra"
 )
 
+// Label identifying the resources managed by the controllers
+const managedByLabel = "app.kubernetes.io/managed-by"
+const managedByValue = "namespace-controller"
+
 var apiserver string
 var kubeconfig string
+var dryRun bool
+var dryRunSummaryInterval time.Duration
+var metricsAddr string
+var reconcileTimeout time.Duration
+var namespaceSelector string
+var includeNamespaces []string
+var excludeNamespaces []string
 
 var rootCmd = &cobra.Command{
 	Use:   "namespace-controller",
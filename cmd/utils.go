@@ -1,32 +1,142 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"sync"
+	"time"
 
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/kubectl/pkg/scheme"
+	"github.com/StatCan/namespace-controller/pkg/controllers/namespaces"
+	"github.com/StatCan/namespace-controller/pkg/dryrun"
+	"github.com/StatCan/namespace-controller/pkg/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
 )
 
-// addTypeInformationToObject adds TypeMeta information to a runtime.Object based upon the loaded scheme.Scheme
-// inspired by: https://github.com/kubernetes/cli-runtime/blob/v0.19.2/pkg/printers/typesetter.go#L41
-//
-// Credit: hjkatz, https://github.com/kubernetes/client-go/issues/308#issuecomment-700099260
-func addTypeInformationToObject(obj runtime.Object) error {
-	gvks, _, err := scheme.Scheme.ObjectKinds(obj)
-	if err != nil {
-		return fmt.Errorf("missing apiVersion or kind and cannot assign it; %w", err)
+// debouncer runs a function once events stop arriving for a period of time,
+// collapsing a burst of events into a single call.
+type debouncer struct {
+	mu    sync.Mutex
+	timer *time.Timer
+	delay time.Duration
+	fn    func()
+}
+
+func newDebouncer(delay time.Duration, fn func()) *debouncer {
+	return &debouncer{
+		delay: delay,
+		fn:    fn,
 	}
+}
 
-	for _, gvk := range gvks {
-		if len(gvk.Kind) == 0 {
-			continue
-		}
-		if len(gvk.Version) == 0 || gvk.Version == runtime.APIVersionInternal {
-			continue
+// Trigger schedules the function to run after the delay, postponing
+// any call which was already scheduled.
+func (d *debouncer) Trigger() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.timer = time.AfterFunc(d.delay, d.fn)
+}
+
+// newChangeRecorder returns the recorder printing the changes of a dry-run,
+// or nil when the --dry-run flag is not set and changes are applied.
+func newChangeRecorder() *dryrun.Recorder {
+	if !dryRun {
+		return nil
+	}
+
+	klog.Info("running in dry-run mode: changes will be reported but not persisted")
+	return dryrun.NewRecorder(os.Stdout)
+}
+
+// forgetDeletedNamespaces forgets the changes to the deleted namespaces in
+// dry-run mode, as they would no longer be made.
+func forgetDeletedNamespaces(builder *namespaces.Builder, changes *dryrun.Recorder) {
+	if changes == nil {
+		return
+	}
+
+	builder.WithFinalize(func(ctx context.Context, namespace *corev1.Namespace) error {
+		changes.ForgetNamespace(namespace.Name)
+		return nil
+	})
+}
+
+// reportChanges prints the summary of the dry-run each interval, when it
+// changed, until ctx is cancelled. As the controllers run until they are
+// stopped, the summary is otherwise only printed on shutdown.
+func reportChanges(ctx context.Context, changes *dryrun.Recorder, interval time.Duration) {
+	if changes == nil {
+		return
+	}
+
+	last := ""
+	wait.Until(func() {
+		summary := changes.Summary()
+		if summary == last {
+			return
 		}
-		obj.GetObjectKind().SetGroupVersionKind(gvk)
-		break
+
+		fmt.Print(summary)
+		last = summary
+	}, interval, ctx.Done())
+}
+
+// addNamespaceSelectionFlags registers the flags selecting the namespaces
+// managed by a command.
+func addNamespaceSelectionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&namespaceSelector, "namespace-selector", "!control-plane", "Label selector of the namespaces to manage; the namespaces of the cluster control plane are skipped by default")
+	cmd.Flags().StringSliceVar(&includeNamespaces, "include-namespaces", nil, "Names of the namespaces to manage, which may be globs (e.g., team-*); all the namespaces matching --namespace-selector when empty")
+	cmd.Flags().StringSliceVar(&excludeNamespaces, "exclude-namespaces", nil, "Names of the namespaces not to manage, which may be globs (e.g., kube-*)")
+}
+
+// newNamespaceFilter returns the filter of the namespaces selected by the
+// --namespace-selector, --include-namespaces and --exclude-namespaces flags.
+func newNamespaceFilter() *namespaces.Filter {
+	filter, err := namespaces.NewFilter(namespaceSelector, includeNamespaces, excludeNamespaces)
+	if err != nil {
+		klog.Fatalf("error selecting namespaces: %v", err)
 	}
 
-	return nil
+	return filter
+}
+
+// newMetricsRegistry returns a registry with the metrics of the process and
+// of the controllers. It must be called before the controllers are created.
+func newMetricsRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	if err := namespaces.RegisterMetrics(registry); err != nil {
+		klog.Fatalf("error registering controller metrics: %v", err)
+	}
+
+	return registry
+}
+
+// startServer serves the health checks and the metrics of registry on
+// --metrics-addr until ctx is cancelled. It returns nil when the server
+// is disabled.
+func startServer(ctx context.Context, registry *prometheus.Registry) *server.Server {
+	if metricsAddr == "" {
+		return nil
+	}
+
+	srv := server.New(metricsAddr, registry)
+	go func() {
+		if err := srv.Serve(ctx); err != nil {
+			klog.Fatalf("error serving health checks and metrics: %v", err)
+		}
+	}()
+
+	return srv
 }
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDebouncer(t *testing.T) {
+	var calls int32
+	d := newDebouncer(50*time.Millisecond, func() {
+		atomic.AddInt32(&calls, 1)
+	})
+
+	// A burst of events results in a single call
+	for i := 0; i < 10; i++ {
+		d.Trigger()
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Fatalf("expected no call before the events settle, got %d", n)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected 1 call after a burst of events, got %d", n)
+	}
+
+	// Later events result in another call
+	d.Trigger()
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("expected 2 calls after another event, got %d", n)
+	}
+}
@@ -7,6 +7,8 @@ go 1.13
 require (
 	github.com/docker/spdystream v0.0.0-20160310174837-449fdfce4d96 // indirect
 	github.com/go-openapi/spec v0.19.3 // indirect
+	github.com/pmezard/go-difflib v1.0.0
+	github.com/prometheus/client_golang v1.7.1
 	github.com/spf13/cobra v1.1.3
 	golang.org/x/tools v0.1.5 // indirect
 	k8s.io/api v0.19.14
@@ -15,6 +17,7 @@ require (
 	k8s.io/code-generator v0.19.14
 	k8s.io/klog v1.0.0
 	k8s.io/kubectl v0.19.14
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
@@ -25,8 +25,11 @@ CODEGEN_PKG=${CODEGEN_PKG:-$(cd "${SCRIPT_ROOT}"; ls -d -1 ./vendor/k8s.io/code-
 # --output-base    because this script should also be able to run inside the vendor dir of
 #                  k8s.io/kubernetes. The output-base is needed for the generators to output into the vendor dir
 #                  instead of the $GOPATH directly. For normal projects this can be dropped.
-bash "${CODEGEN_PKG}"/generate-groups.sh "deepcopy,client,informer,lister" \
-  github.com/StatCan/profiles-controller/pkg/generated github.com/StatCan/profiles-controller/pkg/apis \
-  "kubeflow:v1,v1alpha1" \
+#
+# Only deepcopy functions are generated: the controllers access custom
+# resources through the dynamic client.
+bash "${CODEGEN_PKG}"/generate-groups.sh "deepcopy" \
+  github.com/StatCan/namespace-controller/pkg/generated github.com/StatCan/namespace-controller/pkg/apis \
+  "network:v1alpha1" \
   --output-base "$(dirname "${BASH_SOURCE[0]}")/../../.." \
   --go-header-file "${SCRIPT_ROOT}"/hack/boilerplate.go.txt
@@ -0,0 +1,83 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2020 Her Majesty the Queen in Right of Canada, as represented by the Minister of Statistics Canada
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProfileLabel is the namespace label selecting the NetworkProfile
+// applied to the namespace.
+const ProfileLabel = "network.statcan.gc.ca/profile"
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NetworkProfile is a named bundle of network policies which
+// namespaces opt into using the network.statcan.gc.ca/profile label.
+type NetworkProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NetworkProfileSpec   `json:"spec"`
+	Status NetworkProfileStatus `json:"status,omitempty"`
+}
+
+// NetworkProfileSpec is the desired network configuration of namespaces
+// using the profile.
+type NetworkProfileSpec struct {
+	// Description is a human readable summary of the profile.
+	Description string `json:"description,omitempty"`
+
+	// AllowSameNamespace allows traffic between pods of the namespace.
+	AllowSameNamespace bool `json:"allowSameNamespace,omitempty"`
+
+	// AllowIngressController allows traffic from the ingress gateway.
+	AllowIngressController bool `json:"allowIngressController,omitempty"`
+
+	// Templates restricts the policy templates rendered for the namespace.
+	// When empty, every template is rendered.
+	Templates []string `json:"templates,omitempty"`
+}
+
+// NetworkProfileStatus reports the namespaces using the profile.
+type NetworkProfileStatus struct {
+	// Namespaces is the sorted list of namespaces selecting the profile.
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// NamespaceCount is the number of namespaces selecting the profile.
+	NamespaceCount int `json:"namespaceCount"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NetworkProfileList is a list of NetworkProfile resources
+type NetworkProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []NetworkProfile `json:"items"`
+}
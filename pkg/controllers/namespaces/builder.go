@@ -0,0 +1,238 @@
+package namespaces
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+	"k8s.io/kubectl/pkg/scheme"
+)
+
+// MapFunc returns the name of the namespace to sync when a child object
+// changes, or an empty string to ignore the change.
+type MapFunc func(obj metav1.Object) string
+
+// ByNamespace maps child objects to the namespace containing them.
+func ByNamespace(obj metav1.Object) string {
+	return obj.GetNamespace()
+}
+
+// ByOwner maps child objects to the namespace controlling them, through
+// their owner references. Cluster-scoped objects can be owned by a namespace.
+func ByOwner(obj metav1.Object) string {
+	ownerRef := metav1.GetControllerOf(obj)
+	if ownerRef == nil || ownerRef.Kind != "Namespace" {
+		return ""
+	}
+
+	return ownerRef.Name
+}
+
+// Builder configures a namespace controller and the child resources
+// whose changes sync their namespace.
+//
+//	controller := namespaces.NewBuilder("example", namespaceInformer, sync).
+//		Watches(podInformer.Informer(), namespaces.ByNamespace).
+//		WithThreadiness(4).
+//		Build()
+type Builder struct {
+	name              string
+	namespaceInformer corev1informers.NamespaceInformer
+	sync              namespaceSyncCallback
+	finalize          namespaceSyncCallback
+	finalizer         string
+	kubeClient        kubernetes.Interface
+	threadiness       int
+	resync            time.Duration
+	timeout           time.Duration
+	filter            *Filter
+	watches           []watch
+}
+
+type watch struct {
+	informer       cache.SharedIndexInformer
+	mapToNamespace MapFunc
+}
+
+// NewBuilder creates a builder of a controller calling sync for each
+// namespace. The name identifies the controller in logs and metrics.
+func NewBuilder(name string, namespaceInformer corev1informers.NamespaceInformer, sync namespaceSyncCallback) *Builder {
+	return &Builder{
+		name:              name,
+		namespaceInformer: namespaceInformer,
+		sync:              sync,
+		threadiness:       2,
+	}
+}
+
+// Watches syncs the namespace returned by mapToNamespace when an object of
+// the informer is added, updated or deleted. Re-syncs of the informer,
+// which do not change the objects, are ignored.
+func (b *Builder) Watches(informer cache.SharedIndexInformer, mapToNamespace MapFunc) *Builder {
+	b.watches = append(b.watches, watch{informer: informer, mapToNamespace: mapToNamespace})
+	return b
+}
+
+// WithThreadiness sets the number of namespaces synced concurrently (2 by default).
+func (b *Builder) WithThreadiness(threadiness int) *Builder {
+	b.threadiness = threadiness
+	return b
+}
+
+// WithResync syncs every namespace each period, in addition to the
+// changes of the namespaces and of their children.
+func (b *Builder) WithResync(period time.Duration) *Builder {
+	b.resync = period
+	return b
+}
+
+// WithTimeout cancels the context of each sync after the timeout, so that
+// a namespace whose sync hangs does not hold a worker.
+func (b *Builder) WithTimeout(timeout time.Duration) *Builder {
+	b.timeout = timeout
+	return b
+}
+
+// WithFilter only syncs the namespaces selected by the filter. The other
+// namespaces are skipped before being queued.
+func (b *Builder) WithFilter(filter *Filter) *Builder {
+	b.filter = filter
+	return b
+}
+
+// WithFinalize sets the callback run when a namespace is deleted, to clean
+// up the state tied to the namespace outside of it.
+//
+// Without a finalizer, the callback is given the last known state of the
+// namespace once it is removed, and is not run for the namespaces deleted
+// while the controller is stopped.
+func (b *Builder) WithFinalize(finalize namespaceSyncCallback) *Builder {
+	b.finalize = finalize
+	return b
+}
+
+// WithFinalizer adds the finalizer to the synced namespaces, so that their
+// deletion waits for the finalize callback to succeed. The namespaces are
+// no longer synced once they are terminating. It has no effect without
+// a finalize callback.
+func (b *Builder) WithFinalizer(finalizer string, kubeClient kubernetes.Interface) *Builder {
+	b.finalizer = finalizer
+	b.kubeClient = kubeClient
+	return b
+}
+
+// Build creates the controller and registers its event handlers.
+// The metrics must be registered beforehand for its workqueue to be
+// instrumented.
+func (b *Builder) Build() *Controller {
+	controller := newController(b.name, b.namespaceInformer, b.sync)
+	controller.finalize = b.finalize
+	controller.finalizer = b.finalizer
+	controller.kubeClient = b.kubeClient
+	controller.threadiness = b.threadiness
+	controller.resync = b.resync
+	controller.timeout = b.timeout
+	controller.filter = b.filter
+
+	for _, w := range b.watches {
+		w.informer.AddEventHandler(controller.childEventHandlers(w.mapToNamespace))
+		controller.cacheSyncs = append(controller.cacheSyncs, w.informer.HasSynced)
+	}
+
+	return controller
+}
+
+// childEventHandlers queues the namespace of the child objects which change.
+func (c *Controller) childEventHandlers(mapToNamespace MapFunc) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.enqueueChild(obj, mapToNamespace, "creation")
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			old, err := meta.Accessor(oldObj)
+			if err != nil {
+				utilruntime.HandleError(fmt.Errorf("error decoding object: %v", err))
+				return
+			}
+			new, err := meta.Accessor(newObj)
+			if err != nil {
+				utilruntime.HandleError(fmt.Errorf("error decoding object: %v", err))
+				return
+			}
+
+			// If the resource versions are the same, then the object has not
+			// changed and we don't need to continue processing it. This
+			// happens when the informer is re-synchronized against the API server.
+			if old.GetResourceVersion() == new.GetResourceVersion() {
+				return
+			}
+
+			c.enqueueChild(newObj, mapToNamespace, "update")
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+
+			c.enqueueChild(obj, mapToNamespace, "deletion")
+		},
+	}
+}
+
+// enqueueChild queues the namespace of the child object.
+func (c *Controller) enqueueChild(obj interface{}, mapToNamespace MapFunc, change string) {
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("error decoding object: %v", err))
+		return
+	}
+
+	namespaceName := mapToNamespace(object)
+	if namespaceName == "" {
+		return
+	}
+
+	kind := objectKind(obj)
+	namespace, err := c.namespaceLister.Get(namespaceName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			klog.V(4).Infof("ignoring %s of %s %s/%s as namespace <%s> no longer exists", change, kind, object.GetNamespace(), object.GetName(), namespaceName)
+			return
+		}
+
+		klog.Errorf("failed loading namespace <%s> for %s %s/%s: %v", namespaceName, kind, object.GetNamespace(), object.GetName(), err)
+		return
+	}
+
+	klog.Infof("queuing namespace <%s> for processing due to %s of %s %s/%s", namespace.Name, change, kind, object.GetNamespace(), object.GetName())
+	c.EnqueueNamespace(namespace)
+}
+
+// objectKind returns the kind of the object for logging. The objects of
+// typed informers have no type information, so their kind is looked up
+// in the scheme.
+func objectKind(obj interface{}) string {
+	object, ok := obj.(runtime.Object)
+	if !ok {
+		return fmt.Sprintf("%T", obj)
+	}
+
+	if kind := object.GetObjectKind().GroupVersionKind().Kind; kind != "" {
+		return kind
+	}
+
+	gvks, _, err := scheme.Scheme.ObjectKinds(object)
+	if err != nil || len(gvks) == 0 {
+		return fmt.Sprintf("%T", obj)
+	}
+
+	return gvks[0].Kind
+}
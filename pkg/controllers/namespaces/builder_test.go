@@ -0,0 +1,95 @@
+package namespaces
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestMapFuncs(t *testing.T) {
+	controller := true
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "alpha",
+			Name:      "test",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "v1", Kind: "Namespace", Name: "beta", Controller: &controller},
+			},
+		},
+	}
+
+	if namespace := ByNamespace(policy); namespace != "alpha" {
+		t.Errorf("expected namespace alpha, got %q", namespace)
+	}
+	if namespace := ByOwner(policy); namespace != "beta" {
+		t.Errorf("expected owner beta, got %q", namespace)
+	}
+
+	policy.OwnerReferences[0].Kind = "Deployment"
+	if namespace := ByOwner(policy); namespace != "" {
+		t.Errorf("expected no owner, got %q", namespace)
+	}
+}
+
+func TestWatches(t *testing.T) {
+	alpha := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "alpha"}}
+	tc, _ := newTestController(t, "", alpha)
+	handlers := tc.childEventHandlers(ByNamespace)
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "alpha", Name: "test", ResourceVersion: "1"}}
+	updated := pod.DeepCopy()
+	updated.ResourceVersion = "2"
+
+	tests := []struct {
+		name     string
+		event    func()
+		expected int
+	}{
+		{"add", func() { handlers.OnAdd(pod) }, 1},
+		{"update", func() { handlers.OnUpdate(pod, updated) }, 1},
+		{"resync", func() { handlers.OnUpdate(updated, updated) }, 0},
+		{"delete", func() { handlers.OnDelete(cache.DeletedFinalStateUnknown{Key: "alpha/test", Obj: updated}) }, 1},
+		{"missing namespace", func() {
+			handlers.OnAdd(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "beta", Name: "test"}})
+		}, 0},
+		{"unstructured", func() {
+			obj := &unstructured.Unstructured{}
+			obj.SetAPIVersion("example.com/v1")
+			obj.SetKind("Example")
+			obj.SetNamespace("alpha")
+			obj.SetName("test")
+			handlers.OnAdd(obj)
+		}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.event()
+			if length := tc.workqueue.Len(); length != test.expected {
+				t.Errorf("expected %d queued namespaces, got %d", test.expected, length)
+			}
+
+			for tc.workqueue.Len() > 0 {
+				item, _ := tc.workqueue.Get()
+				tc.workqueue.Forget(item)
+				tc.workqueue.Done(item)
+			}
+		})
+	}
+}
+
+func TestObjectKind(t *testing.T) {
+	if kind := objectKind(&corev1.Pod{}); kind != "Pod" {
+		t.Errorf("expected the kind of a typed object to be looked up, got %q", kind)
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetKind("Example")
+	if kind := objectKind(obj); kind != "Example" {
+		t.Errorf("expected the kind of an unstructured object, got %q", kind)
+	}
+}
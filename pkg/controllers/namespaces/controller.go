@@ -18,31 +18,64 @@ limitations under the License.
 package namespaces
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
 	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 )
 
-type namespaceSyncCallback func(*corev1.Namespace) error
+type namespaceSyncCallback func(context.Context, *corev1.Namespace) error
 
 // Controller struct for informers
 type Controller struct {
+	// name identifies the controller in logs and metrics
+	name string
+
 	namespaceLister corev1listers.NamespaceLister
 	namespaceSynced cache.InformerSynced
 
+	// cacheSyncs of the informers of the watched child objects
+	cacheSyncs []cache.InformerSynced
+
+	// threadiness is the number of workers, resync the period at which
+	// every namespace is synced and timeout the deadline of each sync,
+	// when set
+	threadiness int
+	resync      time.Duration
+	timeout     time.Duration
+
 	// Sync callback will run for each object
 	sync namespaceSyncCallback
 
+	// Finalize callback will run for each deleted object, when set
+	finalize namespaceSyncCallback
+
+	// filter selects the namespaces synced by the controller, when set
+	filter *Filter
+
+	// finalizer delays the deletion of the namespaces until the finalize
+	// callback succeeds, when set
+	finalizer  string
+	kubeClient kubernetes.Interface
+
+	// deleted holds the last known state of the deleted namespaces until
+	// they are finalized, when no finalizer is used
+	deletedMu sync.Mutex
+	deleted   map[string]*corev1.Namespace
+
 	// workqueue is a rate limited work queue. This is used to queue work to be
 	// processed instead of performing it as soon as a change happens. This
 	// means we can ensure we only process a fixed amount of resources at a
@@ -51,16 +84,28 @@ type Controller struct {
 	workqueue workqueue.RateLimitingInterface
 }
 
-// NewController func for event handlers
+// NewController func for event handlers. Use NewBuilder to watch child
+// objects or configure the controller.
 func NewController(
+	name string,
+	namespaceInformer corev1informers.NamespaceInformer,
+	sync namespaceSyncCallback,
+) *Controller {
+	return NewBuilder(name, namespaceInformer, sync).Build()
+}
+
+func newController(
+	name string,
 	namespaceInformer corev1informers.NamespaceInformer,
 	sync namespaceSyncCallback,
 ) *Controller {
 	controller := &Controller{
+		name:            name,
 		namespaceLister: namespaceInformer.Lister(),
 		namespaceSynced: namespaceInformer.Informer().HasSynced,
 		sync:            sync,
-		workqueue:       workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Namespaces"),
+		deleted:         map[string]*corev1.Namespace{},
+		workqueue:       workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), name),
 	}
 
 	// Configure event handlers
@@ -71,16 +116,17 @@ func NewController(
 		UpdateFunc: func(old, new interface{}) {
 			controller.EnqueueNamespace(new)
 		},
+		DeleteFunc: controller.handleDelete,
 	})
 
 	return controller
 }
 
 // Run will set up the event handlers for types we are interested in, as well
-// as syncing informer caches and starting workers. It will block until stopCh
+// as syncing informer caches and starting workers. It will block until ctx
 // is closed, at which point it will shutdown the workqueue and wait for
 // workers to finish processing their current work items.
-func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
+func (c *Controller) Run(ctx context.Context) error {
 	defer utilruntime.HandleCrash()
 	defer c.workqueue.ShutDown()
 
@@ -89,18 +135,23 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 
 	// Wait for the caches to be synced before starting workers
 	klog.Info("waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.namespaceSynced); !ok {
+	if ok := cache.WaitForCacheSync(ctx.Done(), append([]cache.InformerSynced{c.namespaceSynced}, c.cacheSyncs...)...); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
 	klog.Info("starting workers")
-	// Launch two workers to process Namespace resources
-	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+	// Launch the workers to process Namespace resources
+	for i := 0; i < c.threadiness; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+
+	// Periodically sync every namespace, when enabled
+	if c.resync > 0 {
+		go wait.Until(c.EnqueueAllNamespaces, c.resync, ctx.Done())
 	}
 
 	klog.Info("Started workers")
-	<-stopCh
+	<-ctx.Done()
 	klog.Info("Shutting down workers")
 
 	return nil
@@ -109,14 +160,14 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 // runWorker is a long-running function that will continually call the
 // processNextWorkItem function in order to read and process a message on the
 // workqueue.
-func (c *Controller) runWorker() {
-	for c.processNextWorkItem() {
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextWorkItem(ctx) {
 	}
 }
 
 // processNextWorkItem will read a single work item off the workqueue and
 // attempt to process it, by calling the syncHandler.
-func (c *Controller) processNextWorkItem() bool {
+func (c *Controller) processNextWorkItem(ctx context.Context) bool {
 	obj, shutdown := c.workqueue.Get()
 
 	if shutdown {
@@ -149,13 +200,27 @@ func (c *Controller) processNextWorkItem() bool {
 		}
 		// Run the syncHandler, passing it the namespace/name string of the
 		// Namespace resource to be synced.
-		if err := c.syncHandler(key); err != nil {
+		start := time.Now()
+		err := c.syncWithTimeout(ctx, key)
+		reconcileDuration.WithLabelValues(c.name).Observe(time.Since(start).Seconds())
+		if err != nil {
+			reconcileTotal.WithLabelValues(c.name, resultError).Inc()
+
 			// Put the item back on the workqueue to handle any transient errors.
 			c.workqueue.AddRateLimited(key)
+
+			// Conflicts are expected when objects are modified concurrently,
+			// and are resolved by syncing again with the latest objects.
+			if errors.IsConflict(err) {
+				klog.V(2).Infof("conflict syncing '%s': %s, requeuing", key, err.Error())
+				return nil
+			}
+
 			return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
 		}
 		// Finally, if no error occurs we Forget this item so it does not
 		// get queued again until another change happens.
+		reconcileTotal.WithLabelValues(c.name, resultSuccess).Inc()
 		c.workqueue.Forget(obj)
 		klog.Infof("Successfully synced '%s'", key)
 		return nil
@@ -169,30 +234,168 @@ func (c *Controller) processNextWorkItem() bool {
 	return true
 }
 
+// syncWithTimeout runs the syncHandler, cancelling it after the timeout
+// of the controller, when set.
+func (c *Controller) syncWithTimeout(ctx context.Context, key string) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	return c.syncHandler(ctx, key)
+}
+
 // syncHandler compares the actual state with the desired, and attempts to
 // converge the two. It then updates the Status block of the Namespace resource
 // with the current status of the resource.
-func (c *Controller) syncHandler(key string) error {
+func (c *Controller) syncHandler(ctx context.Context, key string) error {
 	// Get the Namespace resource with this namespace/name
 	namespace, err := c.namespaceLister.Get(key)
 	if err != nil {
-		// The Namespace resource may no longer exist, in which case we stop
-		// processing.
+		// The Namespace resource may no longer exist, in which case we
+		// finalize it when it was deleted, and stop processing otherwise.
 		if errors.IsNotFound(err) {
-			utilruntime.HandleError(fmt.Errorf("namespace '%s' in work queue no longer exists", key))
+			return c.finalizeDeleted(ctx, key)
+		}
+
+		return err
+	}
+
+	useFinalizer := c.finalizer != "" && c.finalize != nil
+
+	// Terminating namespaces are finalized instead of synced: through the
+	// finalizer when it is used, and once they are deleted otherwise
+	if namespace.DeletionTimestamp != nil {
+		if !useFinalizer || !hasFinalizer(namespace, c.finalizer) {
+			klog.V(4).Infof("skipping namespace <%s> as it is terminating", namespace.Name)
 			return nil
 		}
 
+		if err := c.finalize(ctx, namespace); err != nil {
+			return err
+		}
+
+		return c.updateFinalizers(ctx, namespace, removeFinalizer(namespace.Finalizers, c.finalizer))
+	}
+
+	// Namespaces which are no longer selected are left as they are
+	if !c.filter.Matches(namespace) {
+		klog.V(4).Infof("skipping namespace <%s> as it is not selected", namespace.Name)
+		return nil
+	}
+
+	if useFinalizer && !hasFinalizer(namespace, c.finalizer) {
+		finalizers := append(append([]string{}, namespace.Finalizers...), c.finalizer)
+		if err := c.updateFinalizers(ctx, namespace, finalizers); err != nil {
+			return err
+		}
+	}
+
+	return c.sync(ctx, namespace)
+}
+
+// finalizeDeleted runs the finalize callback with the last known state of
+// the deleted namespace.
+func (c *Controller) finalizeDeleted(ctx context.Context, key string) error {
+	c.deletedMu.Lock()
+	namespace, ok := c.deleted[key]
+	c.deletedMu.Unlock()
+
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("namespace '%s' in work queue no longer exists", key))
+		return nil
+	}
+
+	if err := c.finalize(ctx, namespace); err != nil {
 		return err
 	}
 
-	return c.sync(namespace)
+	c.deletedMu.Lock()
+	if c.deleted[key] == namespace {
+		delete(c.deleted, key)
+	}
+	c.deletedMu.Unlock()
+
+	return nil
+}
+
+// handleDelete enqueues the deleted namespaces to be finalized, when
+// a finalize callback is set and no finalizer is used.
+func (c *Controller) handleDelete(obj interface{}) {
+	if c.finalize == nil || c.finalizer != "" {
+		return
+	}
+
+	namespace, ok := obj.(*corev1.Namespace)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		namespace, ok = tombstone.Obj.(*corev1.Namespace)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+	}
+
+	if !c.filter.Matches(namespace) {
+		return
+	}
+
+	c.deletedMu.Lock()
+	c.deleted[namespace.Name] = namespace
+	c.deletedMu.Unlock()
+
+	c.EnqueueNamespace(namespace)
+}
+
+// updateFinalizers sets the finalizers of the namespace.
+func (c *Controller) updateFinalizers(ctx context.Context, namespace *corev1.Namespace, finalizers []string) error {
+	updated := namespace.DeepCopy()
+	updated.Finalizers = finalizers
+
+	if _, err := c.kubeClient.CoreV1().Namespaces().Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update the finalizers of namespace %s: %w", namespace.Name, err)
+	}
+
+	return nil
+}
+
+func hasFinalizer(namespace *corev1.Namespace, finalizer string) bool {
+	for _, f := range namespace.Finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+
+	return false
+}
+
+func removeFinalizer(finalizers []string, finalizer string) []string {
+	result := []string{}
+	for _, f := range finalizers {
+		if f != finalizer {
+			result = append(result, f)
+		}
+	}
+
+	return result
 }
 
 // EnqueueNamespace takes a Namespace resource and converts it into a namespace/name
 // string which is then put onto the work queue. This method should *not* be
-// passed resources of any type other than Namespace.
+// passed resources of any type other than Namespace. Namespaces which are
+// not selected by the filter of the controller are skipped, unless they
+// carry its finalizer.
 func (c *Controller) EnqueueNamespace(obj interface{}) {
+	if namespace, ok := obj.(*corev1.Namespace); ok && !c.filter.Matches(namespace) && !(c.finalizer != "" && hasFinalizer(namespace, c.finalizer)) {
+		klog.V(4).Infof("skipping namespace <%s> as it is not selected", namespace.Name)
+		return
+	}
+
 	var key string
 	var err error
 	if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
@@ -202,6 +405,21 @@ func (c *Controller) EnqueueNamespace(obj interface{}) {
 	c.workqueue.Add(key)
 }
 
+// EnqueueAllNamespaces enqueues every namespace in the informer cache.
+// This is used when a change outside of the namespaces (such as the
+// configuration of the controller) affects all of them.
+func (c *Controller) EnqueueAllNamespaces() {
+	namespaces, err := c.namespaceLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to list namespaces: %v", err))
+		return
+	}
+
+	for _, namespace := range namespaces {
+		c.EnqueueNamespace(namespace)
+	}
+}
+
 // HandleObject will take any resource implementing metav1.Object and attempt
 // to find the Namespace resource that 'owns' it. It does this by looking at the
 // objects metadata.ownerReferences field for an appropriate OwnerReference.
@@ -0,0 +1,173 @@
+package namespaces
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+const testFinalizer = "namespace-controller.statcan.gc.ca/test"
+
+// testController records the namespaces passed to the callbacks of a controller
+// whose informer cache is filled by the tests.
+type testController struct {
+	*Controller
+	indexer   cache.Indexer
+	synced    []string
+	finalized []string
+}
+
+func newTestController(t *testing.T, finalizer string, objects ...*corev1.Namespace) (*testController, *fake.Clientset) {
+	t.Helper()
+
+	kubeClient := fake.NewSimpleClientset()
+	informerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, 0)
+	namespaceInformer := informerFactory.Core().V1().Namespaces()
+
+	tc := &testController{indexer: namespaceInformer.Informer().GetIndexer()}
+	builder := NewBuilder("test", namespaceInformer, func(ctx context.Context, namespace *corev1.Namespace) error {
+		tc.synced = append(tc.synced, namespace.Name)
+		return nil
+	})
+	builder.WithFinalize(func(ctx context.Context, namespace *corev1.Namespace) error {
+		tc.finalized = append(tc.finalized, namespace.Name)
+		return nil
+	})
+	if finalizer != "" {
+		builder.WithFinalizer(finalizer, kubeClient)
+	}
+	tc.Controller = builder.Build()
+	t.Cleanup(tc.workqueue.ShutDown)
+
+	for _, namespace := range objects {
+		if _, err := kubeClient.CoreV1().Namespaces().Create(context.Background(), namespace, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create namespace: %v", err)
+		}
+		if err := tc.indexer.Add(namespace); err != nil {
+			t.Fatalf("failed to add namespace: %v", err)
+		}
+	}
+
+	return tc, kubeClient
+}
+
+func TestFinalizeDeletedNamespace(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "alpha"}}
+	tc, _ := newTestController(t, "")
+
+	tc.handleDelete(cache.DeletedFinalStateUnknown{Key: "alpha", Obj: namespace})
+	tc.processNextWorkItem(context.Background())
+
+	if !reflect.DeepEqual(tc.finalized, []string{"alpha"}) {
+		t.Errorf("expected the deleted namespace to be finalized, got %v", tc.finalized)
+	}
+	if len(tc.deleted) != 0 {
+		t.Errorf("expected the finalized namespace to be forgotten")
+	}
+
+	// Namespaces which were not deleted are dropped
+	tc.EnqueueNamespace(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "beta"}})
+	tc.processNextWorkItem(context.Background())
+	if len(tc.synced) != 0 || len(tc.finalized) != 1 {
+		t.Errorf("expected a missing namespace to be skipped, got synced %v and finalized %v", tc.synced, tc.finalized)
+	}
+}
+
+func TestFinalizer(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "alpha", Finalizers: []string{"other"}}}
+	tc, kubeClient := newTestController(t, testFinalizer, namespace)
+
+	// The finalizer is added to the synced namespaces
+	tc.EnqueueNamespace(namespace)
+	tc.processNextWorkItem(context.Background())
+
+	updated, err := kubeClient.CoreV1().Namespaces().Get(context.Background(), "alpha", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get namespace: %v", err)
+	}
+	if expected := []string{"other", testFinalizer}; !reflect.DeepEqual(updated.Finalizers, expected) {
+		t.Errorf("expected finalizers %v, got %v", expected, updated.Finalizers)
+	}
+	if !reflect.DeepEqual(tc.synced, []string{"alpha"}) {
+		t.Errorf("expected the namespace to be synced, got %v", tc.synced)
+	}
+
+	// Terminating namespaces are finalized instead of synced
+	now := metav1.Now()
+	updated.DeletionTimestamp = &now
+	if err := tc.indexer.Update(updated); err != nil {
+		t.Fatalf("failed to update namespace: %v", err)
+	}
+	tc.EnqueueNamespace(updated)
+	tc.processNextWorkItem(context.Background())
+
+	if !reflect.DeepEqual(tc.finalized, []string{"alpha"}) || len(tc.synced) != 1 {
+		t.Errorf("expected the terminating namespace to be finalized only, got synced %v and finalized %v", tc.synced, tc.finalized)
+	}
+	updated, err = kubeClient.CoreV1().Namespaces().Get(context.Background(), "alpha", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get namespace: %v", err)
+	}
+	if expected := []string{"other"}; !reflect.DeepEqual(updated.Finalizers, expected) {
+		t.Errorf("expected finalizers %v, got %v", expected, updated.Finalizers)
+	}
+
+	// The deletion events are ignored when the finalizer is used
+	tc.handleDelete(updated)
+	if tc.workqueue.Len() != 0 {
+		t.Errorf("expected the deleted namespace not to be queued")
+	}
+}
+
+func TestTerminatingNamespace(t *testing.T) {
+	now := metav1.Now()
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "alpha", DeletionTimestamp: &now}}
+	tc, _ := newTestController(t, "", namespace)
+
+	// Terminating namespaces are not synced without a finalizer
+	tc.EnqueueNamespace(namespace)
+	tc.processNextWorkItem(context.Background())
+	if len(tc.synced) != 0 || len(tc.finalized) != 0 {
+		t.Errorf("expected the terminating namespace to be skipped, got synced %v and finalized %v", tc.synced, tc.finalized)
+	}
+
+	// They are finalized once deleted
+	if err := tc.indexer.Delete(namespace); err != nil {
+		t.Fatalf("failed to delete namespace: %v", err)
+	}
+	tc.handleDelete(namespace)
+	tc.processNextWorkItem(context.Background())
+	if len(tc.synced) != 0 || !reflect.DeepEqual(tc.finalized, []string{"alpha"}) {
+		t.Errorf("expected the deleted namespace to be finalized only, got synced %v and finalized %v", tc.synced, tc.finalized)
+	}
+}
+
+func TestSyncTimeout(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "alpha"}}
+	informerFactory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	namespaceInformer := informerFactory.Core().V1().Namespaces()
+	if err := namespaceInformer.Informer().GetIndexer().Add(namespace); err != nil {
+		t.Fatalf("failed to add namespace: %v", err)
+	}
+
+	var deadline time.Time
+	controller := NewBuilder("test", namespaceInformer, func(ctx context.Context, namespace *corev1.Namespace) error {
+		deadline, _ = ctx.Deadline()
+		return nil
+	}).WithTimeout(time.Minute).Build()
+	defer controller.workqueue.ShutDown()
+
+	controller.EnqueueNamespace(namespace)
+	controller.processNextWorkItem(context.Background())
+
+	if deadline.IsZero() || time.Until(deadline) > time.Minute {
+		t.Errorf("expected the sync to be given a deadline within a minute, got %v", deadline)
+	}
+}
@@ -0,0 +1,67 @@
+package namespaces
+
+import (
+	"fmt"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Filter selects the namespaces managed by a controller, by their labels
+// and by their names. The names may be globs (e.g., kube-*).
+type Filter struct {
+	selector labels.Selector
+	include  []string
+	exclude  []string
+}
+
+// NewFilter creates a filter selecting the namespaces whose labels match
+// the selector and whose name matches one of the include patterns, when
+// provided, but none of the exclude patterns.
+func NewFilter(selector string, include, exclude []string) (*Filter, error) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace selector %q: %w", selector, err)
+	}
+
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q: %w", pattern, err)
+		}
+	}
+
+	return &Filter{
+		selector: parsed,
+		include:  include,
+		exclude:  exclude,
+	}, nil
+}
+
+// Matches returns true if the namespace is selected by the filter.
+// A nil filter selects every namespace.
+func (f *Filter) Matches(namespace *corev1.Namespace) bool {
+	if f == nil {
+		return true
+	}
+
+	if !f.selector.Matches(labels.Set(namespace.Labels)) {
+		return false
+	}
+
+	if len(f.include) > 0 && !matchesAny(f.include, namespace.Name) {
+		return false
+	}
+
+	return !matchesAny(f.exclude, namespace.Name)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,115 @@
+package namespaces
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		include  []string
+		exclude  []string
+		expected []string
+	}{
+		{
+			name:     "everything",
+			expected: []string{"alpha", "kube-system", "team-a", "team-b"},
+		},
+		{
+			name:     "selector",
+			selector: "!control-plane",
+			expected: []string{"alpha", "team-a", "team-b"},
+		},
+		{
+			name:     "include",
+			selector: "!control-plane",
+			include:  []string{"team-*", "kube-system"},
+			expected: []string{"team-a", "team-b"},
+		},
+		{
+			name:     "exclude",
+			include:  []string{"team-*", "kube-system"},
+			exclude:  []string{"team-b", "kube-*"},
+			expected: []string{"team-a"},
+		},
+	}
+
+	namespaces := []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "alpha"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", Labels: map[string]string{"control-plane": "true"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := NewFilter(test.selector, test.include, test.exclude)
+			if err != nil {
+				t.Fatalf("failed to create filter: %v", err)
+			}
+
+			matched := []string{}
+			for _, namespace := range namespaces {
+				if filter.Matches(namespace) {
+					matched = append(matched, namespace.Name)
+				}
+			}
+
+			if !reflect.DeepEqual(matched, test.expected) {
+				t.Errorf("expected %v to match, got %v", test.expected, matched)
+			}
+		})
+	}
+
+	// A nil filter selects every namespace
+	var filter *Filter
+	if !filter.Matches(namespaces[1]) {
+		t.Errorf("expected a nil filter to match every namespace")
+	}
+}
+
+func TestFilterInvalid(t *testing.T) {
+	if _, err := NewFilter("control-plane in (", nil, nil); err == nil {
+		t.Errorf("expected an invalid selector to fail")
+	}
+	if _, err := NewFilter("", []string{"team-["}, nil); err == nil {
+		t.Errorf("expected an invalid include pattern to fail")
+	}
+	if _, err := NewFilter("", nil, []string{"kube-["}); err == nil {
+		t.Errorf("expected an invalid exclude pattern to fail")
+	}
+}
+
+func TestControllerFilter(t *testing.T) {
+	selected := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "alpha"}}
+	unselected := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", Labels: map[string]string{"control-plane": "true"}}}
+	tc, _ := newTestController(t, "", selected, unselected)
+
+	filter, err := NewFilter("!control-plane", nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+	tc.filter = filter
+
+	// The namespaces which are not selected are not queued
+	tc.EnqueueAllNamespaces()
+	if tc.workqueue.Len() != 1 {
+		t.Fatalf("expected 1 namespace to be queued, got %d", tc.workqueue.Len())
+	}
+	tc.processNextWorkItem(context.Background())
+	if !reflect.DeepEqual(tc.synced, []string{"alpha"}) {
+		t.Errorf("expected the selected namespace to be synced, got %v", tc.synced)
+	}
+
+	// Nor are they finalized when deleted
+	tc.handleDelete(unselected)
+	if tc.workqueue.Len() != 0 || len(tc.deleted) != 0 {
+		t.Errorf("expected the deletion of an unselected namespace to be ignored")
+	}
+}
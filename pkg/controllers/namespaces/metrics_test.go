@@ -0,0 +1,60 @@
+package namespaces
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	if err := RegisterMetrics(registry); err != nil {
+		t.Fatalf("failed to register metrics: %v", err)
+	}
+
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "alpha"}}
+	informerFactory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	namespaceInformer := informerFactory.Core().V1().Namespaces()
+	if err := namespaceInformer.Informer().GetIndexer().Add(namespace); err != nil {
+		t.Fatalf("failed to add namespace: %v", err)
+	}
+
+	fail := true
+	controller := NewController("metrics", namespaceInformer, func(context.Context, *corev1.Namespace) error {
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	defer controller.workqueue.ShutDown()
+
+	controller.EnqueueNamespace(namespace)
+	controller.processNextWorkItem(context.Background())
+	fail = false
+	controller.processNextWorkItem(context.Background())
+
+	if value := testutil.ToFloat64(reconcileTotal.WithLabelValues("metrics", resultError)); value != 1 {
+		t.Errorf("expected 1 failed reconcile, got %v", value)
+	}
+	if value := testutil.ToFloat64(reconcileTotal.WithLabelValues("metrics", resultSuccess)); value != 1 {
+		t.Errorf("expected 1 successful reconcile, got %v", value)
+	}
+	if value := testutil.ToFloat64(workqueueRetries.WithLabelValues("metrics")); value != 1 {
+		t.Errorf("expected 1 retry, got %v", value)
+	}
+	if value := testutil.ToFloat64(workqueueDepth.WithLabelValues("metrics")); value != 0 {
+		t.Errorf("expected an empty workqueue, got a depth of %v", value)
+	}
+
+	// Registering the metrics twice fails
+	if err := RegisterMetrics(registry); err == nil {
+		t.Errorf("expected an error registering the metrics twice")
+	}
+}
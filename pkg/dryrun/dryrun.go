@@ -0,0 +1,203 @@
+// Package dryrun reports the changes the controllers would make
+// when running in dry-run mode.
+package dryrun
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pmezard/go-difflib/difflib"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// Action is a change made to an object.
+type Action string
+
+// Actions reported by the recorder
+const (
+	Create Action = "create"
+	Update Action = "update"
+	Delete Action = "delete"
+)
+
+// Options returns the value of the DryRun field of the create, update,
+// patch and delete options: all stages when enabled, none otherwise.
+func Options(enabled bool) []string {
+	if enabled {
+		return []string{metav1.DryRunAll}
+	}
+
+	return nil
+}
+
+// Recorder prints a unified diff for each change and keeps count of the
+// objects which would change. As the controllers repeatedly reconcile the
+// same objects without changing them, a diff is only printed the first
+// time it is seen for an object.
+//
+// The controllers are given a recorder in dry-run mode, and nil otherwise:
+// a nil recorder records and forgets nothing.
+type Recorder struct {
+	mu      sync.Mutex
+	out     io.Writer
+	changes map[string]change
+}
+
+type change struct {
+	action Action
+	diff   string
+}
+
+// NewRecorder creates a recorder writing diffs to out.
+func NewRecorder(out io.Writer) *Recorder {
+	return &Recorder{
+		out:     out,
+		changes: map[string]change{},
+	}
+}
+
+// Record reports that the object identified by resource, namespace and name
+// would change from current to desired. Either object may be nil, for
+// creations and deletions respectively.
+func (r *Recorder) Record(action Action, resource, namespace, name string, current, desired runtime.Object) error {
+	if r == nil {
+		return nil
+	}
+
+	id := resource + "/" + name
+	if namespace != "" {
+		id = resource + "/" + namespace + "/" + name
+	}
+
+	before, err := toYAML(current)
+	if err != nil {
+		return err
+	}
+	after, err := toYAML(desired)
+	if err != nil {
+		return err
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(before),
+		B:        difflib.SplitLines(after),
+		FromFile: id + " (current)",
+		ToFile:   id + " (desired)",
+		Context:  3,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to compute diff of %s: %w", id, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous, ok := r.changes[id]
+	if ok && previous.diff == diff {
+		return nil
+	}
+
+	// An object which would be created does not exist yet, so updating it
+	// after its creation (e.g. to apply defaults) still counts as a creation
+	summaryAction := action
+	if ok && previous.action == Create && action == Update {
+		summaryAction = Create
+	}
+	r.changes[id] = change{action: summaryAction, diff: diff}
+
+	fmt.Fprintf(r.out, "# dry-run: would %s %s\n%s\n", action, id, diff)
+	return nil
+}
+
+// Forget removes an object from the summary, once it no longer differs
+// from its desired state.
+func (r *Recorder) Forget(resource, namespace, name string) {
+	if r == nil {
+		return
+	}
+
+	id := resource + "/" + name
+	if namespace != "" {
+		id = resource + "/" + namespace + "/" + name
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.changes, id)
+}
+
+// ForgetNamespace forgets the changes to a deleted namespace and to the
+// objects it contained.
+func (r *Recorder) ForgetNamespace(namespace string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id := range r.changes {
+		parts := strings.Split(id, "/")
+		if (len(parts) == 3 && parts[1] == namespace) || id == "namespaces/"+namespace {
+			delete(r.changes, id)
+		}
+	}
+}
+
+// Summary returns the number of objects which would be created, updated
+// and deleted, by resource.
+func (r *Recorder) Summary() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := map[string]map[Action]int{}
+	for id, change := range r.changes {
+		resource := strings.SplitN(id, "/", 2)[0]
+		if counts[resource] == nil {
+			counts[resource] = map[Action]int{}
+		}
+		counts[resource][change.action]++
+	}
+
+	resources := []string{}
+	for resource := range counts {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "dry-run summary: %d objects would change\n", len(r.changes))
+	for _, resource := range resources {
+		fmt.Fprintf(&b, "  %s: %d to create, %d to update, %d to delete\n", resource, counts[resource][Create], counts[resource][Update], counts[resource][Delete])
+	}
+
+	return b.String()
+}
+
+// toYAML serializes an object for diffing, omitting the fields
+// maintained by the API server.
+func toYAML(obj runtime.Object) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+
+	obj = obj.DeepCopyObject()
+	if accessor, ok := obj.(metav1.Object); ok {
+		accessor.SetManagedFields(nil)
+		accessor.SetResourceVersion("")
+		accessor.SetGeneration(0)
+	}
+
+	b, err := yaml.Marshal(obj)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize object: %w", err)
+	}
+
+	return string(b), nil
+}
@@ -0,0 +1,112 @@
+package dryrun
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newConfigMap(name string, data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "alpha", Name: name},
+		Data:       data,
+	}
+}
+
+func TestRecordSummary(t *testing.T) {
+	var out bytes.Buffer
+	recorder := NewRecorder(&out)
+
+	created := newConfigMap("created", map[string]string{"a": "1"})
+	updated := newConfigMap("updated", map[string]string{"a": "1"})
+	forgotten := newConfigMap("forgotten", map[string]string{"a": "1"})
+
+	records := []struct {
+		action           Action
+		name             string
+		current, desired runtime.Object
+	}{
+		{Create, "created", nil, created},
+		// Updating an object which would be created keeps it a creation
+		{Update, "created", created, newConfigMap("created", map[string]string{"a": "2"})},
+		{Update, "updated", updated, newConfigMap("updated", map[string]string{"a": "2"})},
+		{Update, "forgotten", forgotten, newConfigMap("forgotten", map[string]string{"a": "2"})},
+		{Delete, "deleted", newConfigMap("deleted", nil), nil},
+	}
+	for _, record := range records {
+		if err := recorder.Record(record.action, "configmaps", "alpha", record.name, record.current, record.desired); err != nil {
+			t.Fatalf("failed to record change: %v", err)
+		}
+	}
+	recorder.Forget("configmaps", "alpha", "forgotten")
+
+	expected := "dry-run summary: 3 objects would change\n  configmaps: 1 to create, 1 to update, 1 to delete\n"
+	if summary := recorder.Summary(); summary != expected {
+		t.Errorf("expected summary %q, got %q", expected, summary)
+	}
+
+	if !strings.Contains(out.String(), "# dry-run: would create configmaps/alpha/created\n") {
+		t.Errorf("expected the creation to be printed, got:\n%s", out.String())
+	}
+}
+
+func TestRecordPrintsChangedDiffs(t *testing.T) {
+	var out bytes.Buffer
+	recorder := NewRecorder(&out)
+
+	current := newConfigMap("test", map[string]string{"a": "1"})
+	desired := newConfigMap("test", map[string]string{"a": "2"})
+
+	for i := 0; i < 2; i++ {
+		if err := recorder.Record(Update, "configmaps", "alpha", "test", current, desired); err != nil {
+			t.Fatalf("failed to record change: %v", err)
+		}
+	}
+	if count := strings.Count(out.String(), "# dry-run:"); count != 1 {
+		t.Errorf("expected the same diff to be printed once, got %d times", count)
+	}
+
+	if err := recorder.Record(Update, "configmaps", "alpha", "test", current, newConfigMap("test", map[string]string{"a": "3"})); err != nil {
+		t.Fatalf("failed to record change: %v", err)
+	}
+	if count := strings.Count(out.String(), "# dry-run:"); count != 2 {
+		t.Errorf("expected a new diff to be printed, got %d diffs", count)
+	}
+}
+
+func TestForgetNamespace(t *testing.T) {
+	recorder := NewRecorder(&bytes.Buffer{})
+
+	records := []struct {
+		resource, namespace, name string
+	}{
+		{"configmaps", "alpha", "test"},
+		{"configmaps", "beta", "test"},
+		{"namespaces", "", "alpha"},
+		{"namespaces", "", "beta"},
+	}
+	for _, record := range records {
+		if err := recorder.Record(Create, record.resource, record.namespace, record.name, nil, newConfigMap(record.name, nil)); err != nil {
+			t.Fatalf("failed to record change: %v", err)
+		}
+	}
+	recorder.ForgetNamespace("alpha")
+
+	expected := "dry-run summary: 2 objects would change\n  configmaps: 1 to create, 0 to update, 0 to delete\n  namespaces: 1 to create, 0 to update, 0 to delete\n"
+	if summary := recorder.Summary(); summary != expected {
+		t.Errorf("expected summary %q, got %q", expected, summary)
+	}
+}
+
+func TestNilRecorder(t *testing.T) {
+	var recorder *Recorder
+	if err := recorder.Record(Create, "configmaps", "alpha", "test", nil, newConfigMap("test", nil)); err != nil {
+		t.Errorf("expected a nil recorder to ignore changes, got %v", err)
+	}
+	recorder.Forget("configmaps", "alpha", "test")
+	recorder.ForgetNamespace("alpha")
+}
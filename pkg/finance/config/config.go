@@ -0,0 +1,267 @@
+// Package config defines the configuration file of the finance controller.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// WorkloadIDLabel is the namespace label identifying the workload
+// billed for the resources of the namespace.
+const WorkloadIDLabel = "finance.statcan.gc.ca/workload-id"
+
+// ManagedLabelsAnnotation lists the labels propagated to a resource,
+// so that they can be removed when they are no longer propagated.
+const ManagedLabelsAnnotation = "finance.statcan.gc.ca/managed-labels"
+
+// Kinds of resources the labels are propagated to by default
+const (
+	KindPod                   = "Pod"
+	KindPersistentVolumeClaim = "PersistentVolumeClaim"
+	KindDeployment            = "Deployment"
+	KindStatefulSet           = "StatefulSet"
+	KindDaemonSet             = "DaemonSet"
+	KindJob                   = "Job"
+	KindCronJob               = "CronJob"
+	KindService               = "Service"
+	KindVolumeSnapshot        = "VolumeSnapshot"
+)
+
+// Config is the configuration of the finance controller. It describes
+// the namespace labels propagated to the resources of the namespace.
+type Config struct {
+	// Resources are the kinds of resources the labels can be propagated to.
+	Resources []Resource `json:"resources"`
+
+	Labels []LabelMapping `json:"labels"`
+}
+
+// Resource is a kind of resource the labels can be propagated to,
+// identified by its group, version and resource name.
+type Resource struct {
+	Group    string `json:"group,omitempty"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+
+	// Kind is the kind of the resource, referenced by the label mappings.
+	Kind string `json:"kind"`
+
+	// Match restricts the propagation to the objects whose fields, given as
+	// dot-separated paths, have the given values (e.g., spec.type: LoadBalancer).
+	Match map[string]string `json:"match,omitempty"`
+}
+
+// GroupVersionResource returns the group, version and resource name of the resource.
+func (r *Resource) GroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}
+}
+
+// LabelMapping propagates a namespace label to the resources of the namespace.
+type LabelMapping struct {
+	// Source is the key of the namespace label.
+	Source string `json:"source"`
+
+	// Destination is the key of the label set on the resources.
+	// Defaults to the source key.
+	Destination string `json:"destination,omitempty"`
+
+	// Default is the value propagated when the namespace does not have
+	// the source label. When empty, nothing is propagated.
+	Default string `json:"default,omitempty"`
+
+	// Kinds are the kinds of resources the label is propagated to.
+	// Defaults to all the resources of the configuration.
+	Kinds []string `json:"kinds,omitempty"`
+}
+
+// DefaultResources returns the resources billed for chargeback.
+func DefaultResources() []Resource {
+	return []Resource{
+		{Version: "v1", Resource: "pods", Kind: KindPod},
+		{Version: "v1", Resource: "persistentvolumeclaims", Kind: KindPersistentVolumeClaim},
+		{Group: "apps", Version: "v1", Resource: "deployments", Kind: KindDeployment},
+		{Group: "apps", Version: "v1", Resource: "statefulsets", Kind: KindStatefulSet},
+		{Group: "apps", Version: "v1", Resource: "daemonsets", Kind: KindDaemonSet},
+		{Group: "batch", Version: "v1", Resource: "jobs", Kind: KindJob},
+		{Group: "batch", Version: "v1", Resource: "cronjobs", Kind: KindCronJob},
+		{Version: "v1", Resource: "services", Kind: KindService, Match: map[string]string{"spec.type": "LoadBalancer"}},
+		{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshots", Kind: KindVolumeSnapshot},
+	}
+}
+
+// Default returns the configuration propagating the workload-id label
+// to the default resources.
+func Default() *Config {
+	return &Config{
+		Resources: DefaultResources(),
+		Labels: []LabelMapping{
+			{
+				Source:      WorkloadIDLabel,
+				Destination: WorkloadIDLabel,
+			},
+		},
+	}
+}
+
+// Load reads the configuration file at path. The resources and label
+// mappings of the file replace the default ones, which are only used
+// when the file omits them. The configuration is validated.
+func Load(path string) (*Config, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file %q: %w", path, err)
+	}
+
+	// Decode into an empty configuration, as decoding into the default
+	// one would merge the entries of the file with the default entries
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(contents, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration file %q: %w", path, err)
+	}
+
+	defaults := Default()
+	if cfg.Resources == nil {
+		cfg.Resources = defaults.Resources
+	}
+	if cfg.Labels == nil {
+		cfg.Labels = defaults.Labels
+	}
+
+	for i := range cfg.Labels {
+		mapping := &cfg.Labels[i]
+		if mapping.Destination == "" {
+			mapping.Destination = mapping.Source
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %q: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Validate checks that the resources, label keys, default values and kinds
+// of the configuration are valid.
+func (c *Config) Validate() error {
+	kinds := map[string]bool{}
+	for i, resource := range c.Resources {
+		if resource.Version == "" {
+			return fmt.Errorf("resources[%d].version: a version is required", i)
+		}
+		if resource.Resource == "" {
+			return fmt.Errorf("resources[%d].resource: a resource name is required", i)
+		}
+		if resource.Kind == "" {
+			return fmt.Errorf("resources[%d].kind: a kind is required", i)
+		}
+		if kinds[resource.Kind] {
+			return fmt.Errorf("resources[%d].kind: kind %q is already configured", i, resource.Kind)
+		}
+		kinds[resource.Kind] = true
+	}
+
+	destinations := map[string]bool{}
+
+	for i, mapping := range c.Labels {
+		if errs := validation.IsQualifiedName(mapping.Source); len(errs) > 0 {
+			return fmt.Errorf("labels[%d].source: invalid label key %q: %v", i, mapping.Source, errs)
+		}
+
+		if errs := validation.IsQualifiedName(mapping.Destination); len(errs) > 0 {
+			return fmt.Errorf("labels[%d].destination: invalid label key %q: %v", i, mapping.Destination, errs)
+		}
+		if destinations[mapping.Destination] {
+			return fmt.Errorf("labels[%d].destination: label %q is already propagated", i, mapping.Destination)
+		}
+		destinations[mapping.Destination] = true
+
+		if errs := validation.IsValidLabelValue(mapping.Default); len(errs) > 0 {
+			return fmt.Errorf("labels[%d].default: invalid label value %q: %v", i, mapping.Default, errs)
+		}
+
+		for j, kind := range mapping.Kinds {
+			if !kinds[kind] {
+				return fmt.Errorf("labels[%d].kinds[%d]: kind %q is not one of the configured resources", i, j, kind)
+			}
+		}
+	}
+
+	return nil
+}
+
+// LabelsFor returns the labels to set on the resources of the given kind
+// in a namespace with the given labels.
+func (c *Config) LabelsFor(namespaceLabels map[string]string, kind string) map[string]string {
+	labels := map[string]string{}
+
+	for _, mapping := range c.Labels {
+		if !mapping.appliesTo(kind) {
+			continue
+		}
+
+		if value, ok := namespaceLabels[mapping.Source]; ok {
+			labels[mapping.Destination] = value
+		} else if mapping.Default != "" {
+			labels[mapping.Destination] = mapping.Default
+		}
+	}
+
+	return labels
+}
+
+// TargetResources returns the resources at least one label is propagated to.
+func (c *Config) TargetResources() []Resource {
+	resources := []Resource{}
+	for _, resource := range c.Resources {
+		for _, mapping := range c.Labels {
+			if mapping.appliesTo(resource.Kind) {
+				resources = append(resources, resource)
+				break
+			}
+		}
+	}
+
+	return resources
+}
+
+func (m *LabelMapping) appliesTo(kind string) bool {
+	if len(m.Kinds) == 0 {
+		return true
+	}
+
+	for _, k := range m.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseManagedLabels returns the keys listed in the managed labels annotation.
+func ParseManagedLabels(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	return strings.Split(value, ",")
+}
+
+// FormatManagedLabels returns the value of the managed labels annotation
+// listing the keys of the labels, in a stable order.
+func FormatManagedLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, ",")
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// load writes the contents to a configuration file and loads it.
+func load(t *testing.T, contents string) *Config {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write configuration file: %v", err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	return cfg
+}
+
+func TestLoadLabelsReplaceDefaults(t *testing.T) {
+	cfg := load(t, `
+labels:
+- source: owner
+`)
+
+	expected := []LabelMapping{
+		{Source: "owner", Destination: "owner"},
+	}
+	if !reflect.DeepEqual(cfg.Labels, expected) {
+		t.Errorf("expected labels %+v, got %+v", expected, cfg.Labels)
+	}
+
+	if !reflect.DeepEqual(cfg.Resources, DefaultResources()) {
+		t.Errorf("expected the default resources, got %+v", cfg.Resources)
+	}
+}
+
+func TestLoadWithoutLabels(t *testing.T) {
+	cfg := load(t, `
+resources:
+- version: v1
+  resource: pods
+  kind: Pod
+`)
+
+	if !reflect.DeepEqual(cfg.Labels, Default().Labels) {
+		t.Errorf("expected the default labels, got %+v", cfg.Labels)
+	}
+}
+
+func TestLoadResourcesReplaceDefaults(t *testing.T) {
+	cfg := load(t, `
+resources:
+- version: v1
+  resource: pods
+  kind: Pod
+- version: v1
+  resource: persistentvolumeclaims
+  kind: PersistentVolumeClaim
+- group: apps
+  version: v1
+  resource: deployments
+  kind: Deployment
+- group: apps
+  version: v1
+  resource: statefulsets
+  kind: StatefulSet
+- group: apps
+  version: v1
+  resource: daemonsets
+  kind: DaemonSet
+- group: batch
+  version: v1
+  resource: jobs
+  kind: Job
+- group: batch
+  version: v1
+  resource: cronjobs
+  kind: CronJob
+- group: kubeflow.org
+  version: v1
+  resource: notebooks
+  kind: Notebook
+`)
+
+	if len(cfg.Resources) != 8 {
+		t.Fatalf("expected 8 resources, got %d: %+v", len(cfg.Resources), cfg.Resources)
+	}
+
+	// The entry replacing the default Services must not inherit their match
+	expected := Resource{Group: "kubeflow.org", Version: "v1", Resource: "notebooks", Kind: "Notebook"}
+	if !reflect.DeepEqual(cfg.Resources[7], expected) {
+		t.Errorf("expected resource %+v, got %+v", expected, cfg.Resources[7])
+	}
+}
+
+func TestLoadExample(t *testing.T) {
+	cfg, err := Load("../../../manifests/examples/finance-config.yaml")
+	if err != nil {
+		t.Fatalf("failed to load the example configuration: %v", err)
+	}
+
+	kinds := map[string]Resource{}
+	for _, resource := range cfg.Resources {
+		kinds[resource.Kind] = resource
+	}
+
+	if len(kinds[KindService].Match) != 1 || kinds[KindService].Match["spec.type"] != "LoadBalancer" {
+		t.Errorf("expected services to match load balancers, got %+v", kinds[KindService])
+	}
+	if len(kinds["Notebook"].Match) != 0 {
+		t.Errorf("expected notebooks to match all objects, got %+v", kinds["Notebook"])
+	}
+
+	expected := map[string]string{
+		WorkloadIDLabel:                     "alpha-id",
+		"finance.statcan.gc.ca/cost-centre": "unassigned",
+		"finance.statcan.gc.ca/project":     "alpha",
+		"finance.statcan.gc.ca/owner":       "jane",
+	}
+	got := cfg.LabelsFor(map[string]string{
+		WorkloadIDLabel:                 "alpha-id",
+		"finance.statcan.gc.ca/project": "alpha",
+		"owner":                         "jane",
+	}, "Notebook")
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected labels %v, got %v", expected, got)
+	}
+
+	// The project is only propagated to pods and notebooks
+	if _, ok := cfg.LabelsFor(map[string]string{"finance.statcan.gc.ca/project": "alpha"}, KindDeployment)["finance.statcan.gc.ca/project"]; ok {
+		t.Errorf("expected the project not to be propagated to deployments")
+	}
+}
@@ -0,0 +1,175 @@
+// Package metrics exposes the resources requested in each namespace as
+// Prometheus metrics, attributed to the workload-id of the namespace.
+package metrics
+
+import (
+	"github.com/StatCan/namespace-controller/pkg/finance/config"
+	"github.com/StatCan/namespace-controller/pkg/finance/report"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const metricsNamespace = "namespace_controller"
+
+var (
+	podsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "workload", "pods"),
+		"Number of pods which are not terminated in the namespace.",
+		[]string{"workload_id", "namespace"}, nil,
+	)
+	cpuDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "workload", "requested_cpu_cores"),
+		"CPU cores requested by the pods of the namespace.",
+		[]string{"workload_id", "namespace"}, nil,
+	)
+	memoryDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "workload", "requested_memory_bytes"),
+		"Memory requested by the pods of the namespace, in bytes.",
+		[]string{"workload_id", "namespace"}, nil,
+	)
+	gpuDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "workload", "requested_gpus"),
+		"GPUs requested by the pods of the namespace.",
+		[]string{"workload_id", "namespace"}, nil,
+	)
+	storageDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "workload", "pvc_storage_bytes"),
+		"Storage requested by the PersistentVolumeClaims of the namespace, in bytes, by storage class.",
+		[]string{"workload_id", "namespace", "storage_class"}, nil,
+	)
+)
+
+// Collector computes the metrics from the listers on each scrape,
+// so that they always reflect the informer caches. The pods and
+// PersistentVolumeClaims are read from the listers of the propagator,
+// so that they are not cached twice.
+type Collector struct {
+	namespaceLister corev1listers.NamespaceLister
+
+	// podLister and pvcLister list unstructured objects, and
+	// are nil when the labels are not propagated to the kind
+	podLister cache.GenericLister
+	pvcLister cache.GenericLister
+
+	// skip returns true for the namespaces which are not managed
+	skip func(*corev1.Namespace) bool
+}
+
+// NewCollector creates a collector of the resources requested in the namespaces.
+func NewCollector(namespaceLister corev1listers.NamespaceLister, podLister, pvcLister cache.GenericLister, skip func(*corev1.Namespace) bool) *Collector {
+	return &Collector{
+		namespaceLister: namespaceLister,
+		podLister:       podLister,
+		pvcLister:       pvcLister,
+		skip:            skip,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- podsDesc
+	ch <- cpuDesc
+	ch <- memoryDesc
+	ch <- gpuDesc
+	ch <- storageDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	namespaces, err := c.namespaceLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to list namespaces: %v", err)
+		return
+	}
+
+	for _, ns := range namespaces {
+		if c.skip != nil && c.skip(ns) {
+			continue
+		}
+
+		if err := c.collectNamespace(ch, ns); err != nil {
+			klog.Errorf("failed collecting metrics of namespace <%s>: %v", ns.Name, err)
+		}
+	}
+}
+
+func (c *Collector) collectNamespace(ch chan<- prometheus.Metric, ns *corev1.Namespace) error {
+	workloadID := ns.Labels[config.WorkloadIDLabel]
+
+	if c.podLister != nil {
+		if err := c.collectPods(ch, ns.Name, workloadID); err != nil {
+			return err
+		}
+	}
+
+	if c.pvcLister != nil {
+		if err := c.collectPVCs(ch, ns.Name, workloadID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Collector) collectPods(ch chan<- prometheus.Metric, namespace, workloadID string) error {
+	objs, err := c.podLister.ByNamespace(namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	cpu, memory, gpu := 0.0, 0.0, 0.0
+	for _, obj := range objs {
+		pod := &corev1.Pod{}
+		if err := report.FromUnstructured(obj, pod); err != nil {
+			return err
+		}
+
+		if report.IsTerminated(pod) {
+			continue
+		}
+
+		requests := report.PodRequests(pod)
+		count++
+		cpu += float64(requests.Cpu().MilliValue()) / 1000
+		memory += float64(requests.Memory().Value())
+		if quantity, ok := requests[report.GPUResourceName]; ok {
+			gpu += float64(quantity.Value())
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(podsDesc, prometheus.GaugeValue, float64(count), workloadID, namespace)
+	ch <- prometheus.MustNewConstMetric(cpuDesc, prometheus.GaugeValue, cpu, workloadID, namespace)
+	ch <- prometheus.MustNewConstMetric(memoryDesc, prometheus.GaugeValue, memory, workloadID, namespace)
+	ch <- prometheus.MustNewConstMetric(gpuDesc, prometheus.GaugeValue, gpu, workloadID, namespace)
+
+	return nil
+}
+
+func (c *Collector) collectPVCs(ch chan<- prometheus.Metric, namespace, workloadID string) error {
+	objs, err := c.pvcLister.ByNamespace(namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	storage := map[string]float64{}
+	for _, obj := range objs {
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := report.FromUnstructured(obj, pvc); err != nil {
+			return err
+		}
+
+		quantity := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		storage[report.StorageClass(pvc)] += float64(quantity.Value())
+	}
+
+	for storageClass, bytes := range storage {
+		ch <- prometheus.MustNewConstMetric(storageDesc, prometheus.GaugeValue, bytes, workloadID, namespace, storageClass)
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/StatCan/namespace-controller/pkg/finance/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	podsResource = schema.GroupResource{Resource: "pods"}
+	pvcsResource = schema.GroupResource{Resource: "persistentvolumeclaims"}
+)
+
+func newNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+func newPod(namespace, name string, phase corev1.PodPhase, requests ...corev1.ResourceList) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+	for _, r := range requests {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Resources: corev1.ResourceRequirements{Requests: r},
+		})
+	}
+
+	return pod
+}
+
+func newPVC(namespace, name string, storageClass *string, storage string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: storageClass,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(storage)},
+			},
+		},
+	}
+}
+
+func requests(cpu, memory string) corev1.ResourceList {
+	return corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse(cpu),
+		corev1.ResourceMemory: resource.MustParse(memory),
+	}
+}
+
+// newGenericLister returns a lister of the objects as unstructured
+// objects, as listed from the informers of the propagator.
+func newGenericLister(t *testing.T, gr schema.GroupResource, objects ...runtime.Object) cache.GenericLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, obj := range objects {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			t.Fatalf("failed to convert object: %v", err)
+		}
+		if err := indexer.Add(&unstructured.Unstructured{Object: content}); err != nil {
+			t.Fatalf("failed to index object: %v", err)
+		}
+	}
+
+	return cache.NewGenericLister(indexer, gr)
+}
+
+func newNamespaceLister(t *testing.T, namespaces ...*corev1.Namespace) corev1listers.NamespaceLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, ns := range namespaces {
+		if err := indexer.Add(ns); err != nil {
+			t.Fatalf("failed to index namespace: %v", err)
+		}
+	}
+
+	return corev1listers.NewNamespaceLister(indexer)
+}
+
+func isControlPlane(namespace *corev1.Namespace) bool {
+	_, ok := namespace.Labels["control-plane"]
+	return ok
+}
+
+func TestCollect(t *testing.T) {
+	premium := "premium"
+
+	namespaceLister := newNamespaceLister(t,
+		newNamespace("alpha", map[string]string{config.WorkloadIDLabel: "alpha-id"}),
+		newNamespace("beta", nil),
+		newNamespace("kube-system", map[string]string{"control-plane": "true"}),
+	)
+
+	podLister := newGenericLister(t, podsResource,
+		newPod("alpha", "web", corev1.PodRunning, requests("500m", "1Gi"), requests("250m", "512Mi")),
+		newPod("alpha", "gpu", corev1.PodPending, corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("1"),
+			"nvidia.com/gpu":   resource.MustParse("2"),
+		}),
+		newPod("alpha", "done", corev1.PodSucceeded, requests("4", "4Gi")),
+		newPod("kube-system", "dns", corev1.PodRunning, requests("100m", "64Mi")),
+	)
+
+	pvcLister := newGenericLister(t, pvcsResource,
+		newPVC("alpha", "data", &premium, "10Gi"),
+		newPVC("alpha", "more", &premium, "5Gi"),
+		newPVC("beta", "scratch", nil, "1Gi"),
+		newPVC("kube-system", "etcd", &premium, "8Gi"),
+	)
+
+	collector := NewCollector(namespaceLister, podLister, pvcLister, isControlPlane)
+
+	expected := `
+# HELP namespace_controller_workload_pods Number of pods which are not terminated in the namespace.
+# TYPE namespace_controller_workload_pods gauge
+namespace_controller_workload_pods{namespace="alpha",workload_id="alpha-id"} 2
+namespace_controller_workload_pods{namespace="beta",workload_id=""} 0
+# HELP namespace_controller_workload_requested_cpu_cores CPU cores requested by the pods of the namespace.
+# TYPE namespace_controller_workload_requested_cpu_cores gauge
+namespace_controller_workload_requested_cpu_cores{namespace="alpha",workload_id="alpha-id"} 1.75
+namespace_controller_workload_requested_cpu_cores{namespace="beta",workload_id=""} 0
+# HELP namespace_controller_workload_requested_memory_bytes Memory requested by the pods of the namespace, in bytes.
+# TYPE namespace_controller_workload_requested_memory_bytes gauge
+namespace_controller_workload_requested_memory_bytes{namespace="alpha",workload_id="alpha-id"} 1.610612736e+09
+namespace_controller_workload_requested_memory_bytes{namespace="beta",workload_id=""} 0
+# HELP namespace_controller_workload_requested_gpus GPUs requested by the pods of the namespace.
+# TYPE namespace_controller_workload_requested_gpus gauge
+namespace_controller_workload_requested_gpus{namespace="alpha",workload_id="alpha-id"} 2
+namespace_controller_workload_requested_gpus{namespace="beta",workload_id=""} 0
+# HELP namespace_controller_workload_pvc_storage_bytes Storage requested by the PersistentVolumeClaims of the namespace, in bytes, by storage class.
+# TYPE namespace_controller_workload_pvc_storage_bytes gauge
+namespace_controller_workload_pvc_storage_bytes{namespace="alpha",storage_class="premium",workload_id="alpha-id"} 1.610612736e+10
+namespace_controller_workload_pvc_storage_bytes{namespace="beta",storage_class="",workload_id=""} 1.073741824e+09
+`
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectWithoutPodLister(t *testing.T) {
+	namespaceLister := newNamespaceLister(t,
+		newNamespace("alpha", map[string]string{config.WorkloadIDLabel: "alpha-id"}),
+	)
+	pvcLister := newGenericLister(t, pvcsResource, newPVC("alpha", "data", nil, "1Gi"))
+
+	collector := NewCollector(namespaceLister, nil, pvcLister, nil)
+
+	if count := testutil.CollectAndCount(collector, "namespace_controller_workload_pods"); count != 0 {
+		t.Errorf("expected no pod metrics without a pod lister, got %d", count)
+	}
+	if count := testutil.CollectAndCount(collector, "namespace_controller_workload_pvc_storage_bytes"); count != 1 {
+		t.Errorf("expected 1 storage metric, got %d", count)
+	}
+}
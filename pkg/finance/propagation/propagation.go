@@ -0,0 +1,299 @@
+// Package propagation copies the labels of a namespace onto the resources
+// it contains, such as Pods and PersistentVolumeClaims. Resources are
+// handled through the dynamic client, so that any kind (including custom
+// resources) can be configured.
+package propagation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/StatCan/namespace-controller/pkg/dryrun"
+	"github.com/StatCan/namespace-controller/pkg/finance/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// FieldManager identifies the changes made by the propagator.
+const FieldManager = "namespace-controller-finance"
+
+// target is a kind of resource the namespace labels are propagated to.
+type target struct {
+	resource config.Resource
+
+	client   dynamic.NamespaceableResourceInterface
+	informer cache.SharedIndexInformer
+	lister   cache.GenericLister
+}
+
+// Propagator propagates the labels of a namespace to the resources of the
+// namespace, as described by the configuration.
+type Propagator struct {
+	config  *config.Config
+	targets []*target
+	changes *dryrun.Recorder
+}
+
+// NewPropagator creates a propagator for the resources of the configuration,
+// registering their informers with the dynamic informer factory.
+func NewPropagator(cfg *config.Config, dynamicClient dynamic.Interface, dynamicInformerFactory dynamicinformer.DynamicSharedInformerFactory, changes *dryrun.Recorder) *Propagator {
+	p := &Propagator{
+		config:  cfg,
+		changes: changes,
+	}
+
+	for _, resource := range cfg.TargetResources() {
+		gvr := resource.GroupVersionResource()
+		informer := dynamicInformerFactory.ForResource(gvr)
+
+		p.targets = append(p.targets, &target{
+			resource: resource,
+			client:   dynamicClient.Resource(gvr),
+			informer: informer.Informer(),
+			lister:   informer.Lister(),
+		})
+	}
+
+	return p
+}
+
+// AvailableResources returns the resources served by the API server,
+// so that optional resources (e.g., VolumeSnapshots) are skipped when
+// their CRD is not installed.
+func AvailableResources(discoveryClient discovery.DiscoveryInterface, resources []config.Resource) []config.Resource {
+	available := []config.Resource{}
+	for _, resource := range resources {
+		gvr := resource.GroupVersionResource()
+
+		list, err := discoveryClient.ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+		if err != nil {
+			klog.Warningf("%s are unavailable (%v); labels will not be propagated to them", gvr.String(), err)
+			continue
+		}
+
+		served := false
+		for _, apiResource := range list.APIResources {
+			if apiResource.Name == gvr.Resource {
+				served = true
+				break
+			}
+		}
+		if !served {
+			klog.Warningf("%s are not served by the API server; labels will not be propagated to them", gvr.String())
+			continue
+		}
+
+		available = append(available, resource)
+	}
+
+	return available
+}
+
+// Informers returns the informers of the resources the labels are propagated to.
+func (p *Propagator) Informers() []cache.SharedIndexInformer {
+	informers := []cache.SharedIndexInformer{}
+	for _, t := range p.targets {
+		informers = append(informers, t.informer)
+	}
+
+	return informers
+}
+
+// Lister returns the lister of the resources of the given kind,
+// or nil when the labels are not propagated to the kind.
+func (p *Propagator) Lister(kind string) cache.GenericLister {
+	for _, t := range p.targets {
+		if t.resource.Kind == kind {
+			return t.lister
+		}
+	}
+
+	return nil
+}
+
+// Sync propagates the labels of the namespace to the resources it contains.
+// Only the resources of the namespace are listed and patched. The labels
+// set by the propagator are recorded in an annotation, so that they are
+// removed from the resources once they are no longer propagated.
+func (p *Propagator) Sync(ctx context.Context, namespace *corev1.Namespace) error {
+	for _, t := range p.targets {
+		// Objects are synced even when no label is desired, so that
+		// labels which are no longer propagated are removed
+		desired := p.config.LabelsFor(namespace.Labels, t.resource.Kind)
+		if len(desired) > 0 {
+			klog.Infof("propagating namespace <%v> labels to %s", namespace.Name, t.resource.Resource)
+		}
+
+		objs, err := t.lister.ByNamespace(namespace.Name).List(labels.Everything())
+		if err != nil {
+			return fmt.Errorf("failed to list %s under namespace %s: %v", t.resource.Resource, namespace.Name, err)
+		}
+
+		for _, obj := range objs {
+			if !t.matches(obj) {
+				continue
+			}
+
+			if err := p.syncObject(ctx, t, namespace, obj, desired); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// matches returns true if the fields of the object have the values
+// required by the resource.
+func (t *target) matches(obj runtime.Object) bool {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return false
+	}
+
+	for path, expected := range t.resource.Match {
+		value, found, err := unstructured.NestedFieldNoCopy(u.Object, strings.Split(path, ".")...)
+		if err != nil || !found || fmt.Sprint(value) != expected {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *Propagator) syncObject(ctx context.Context, t *target, namespace *corev1.Namespace, obj runtime.Object, desired map[string]string) error {
+	current, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	// Never modify resources outside of the namespace being synced
+	if current.GetNamespace() != namespace.Name {
+		return fmt.Errorf("%s %s/%s is not in namespace %s", t.resource.Kind, current.GetNamespace(), current.GetName(), namespace.Name)
+	}
+
+	// Labels set by a previous sync which are no longer propagated,
+	// because the source label was removed from the namespace
+	stale := []string{}
+	for _, key := range config.ParseManagedLabels(current.GetAnnotations()[config.ManagedLabelsAnnotation]) {
+		if _, ok := desired[key]; !ok {
+			if _, ok := current.GetLabels()[key]; ok {
+				stale = append(stale, key)
+			}
+		}
+	}
+
+	managed := config.FormatManagedLabels(desired)
+	if hasLabels(current.GetLabels(), desired) && len(stale) == 0 && current.GetAnnotations()[config.ManagedLabelsAnnotation] == managed {
+		p.changes.Forget(t.resource.Resource, current.GetNamespace(), current.GetName())
+		return nil
+	}
+
+	// Copy the object, as objects from the cache must not be modified
+	updatedObj := obj.DeepCopyObject()
+	updated, _ := meta.Accessor(updatedObj)
+	updatedLabels := mergeLabels(updated.GetLabels(), desired)
+	for _, key := range stale {
+		delete(updatedLabels, key)
+	}
+	updated.SetLabels(updatedLabels)
+
+	updatedAnnotations := updated.GetAnnotations()
+	if managed != "" {
+		if updatedAnnotations == nil {
+			updatedAnnotations = map[string]string{}
+		}
+		updatedAnnotations[config.ManagedLabelsAnnotation] = managed
+	} else {
+		delete(updatedAnnotations, config.ManagedLabelsAnnotation)
+	}
+	updated.SetAnnotations(updatedAnnotations)
+
+	if err := p.changes.Record(dryrun.Update, t.resource.Resource, current.GetNamespace(), current.GetName(), obj, updatedObj); err != nil {
+		return err
+	}
+
+	// Only patch the labels, so that concurrent writes to the rest of the
+	// object (e.g., the status written by the kubelet) do not conflict.
+	// The patch has no resourceVersion precondition: labels changed since
+	// the object was cached are corrected by the next sync of the namespace.
+	data, err := labelsPatch(current.GetLabels(), desired, stale, managed)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.client.Namespace(current.GetNamespace()).Patch(ctx, current.GetName(), types.MergePatchType, data, metav1.PatchOptions{
+		FieldManager: FieldManager,
+		DryRun:       dryrun.Options(p.changes != nil),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to patch %s %s/%s: %w", t.resource.Kind, current.GetNamespace(), current.GetName(), err)
+	}
+
+	return nil
+}
+
+// labelsPatch returns a JSON merge patch setting the desired labels
+// which differ from the current labels, removing the stale labels
+// and recording the managed labels in an annotation.
+func labelsPatch(current, desired map[string]string, stale []string, managed string) ([]byte, error) {
+	labels := map[string]interface{}{}
+	for key, value := range desired {
+		if existing, ok := current[key]; !ok || existing != value {
+			labels[key] = value
+		}
+	}
+	for _, key := range stale {
+		labels[key] = nil
+	}
+
+	var managedValue interface{}
+	if managed != "" {
+		managedValue = managed
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": labels,
+			"annotations": map[string]interface{}{
+				config.ManagedLabelsAnnotation: managedValue,
+			},
+		},
+	})
+}
+
+// hasLabels returns true if all the desired labels are set to the desired value.
+func hasLabels(current, desired map[string]string) bool {
+	for key, value := range desired {
+		if existing, ok := current[key]; !ok || existing != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// mergeLabels sets the desired labels on the current labels,
+// allocating the map if needed.
+func mergeLabels(current, desired map[string]string) map[string]string {
+	if current == nil {
+		current = map[string]string{}
+	}
+	for key, value := range desired {
+		current[key] = value
+	}
+
+	return current
+}
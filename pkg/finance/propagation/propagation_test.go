@@ -0,0 +1,310 @@
+package propagation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/StatCan/namespace-controller/pkg/finance/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	podsResource     = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	pvcsResource     = schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}
+	servicesResource = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+)
+
+// fixture runs a propagator against a fake dynamic client.
+type fixture struct {
+	t          *testing.T
+	client     *dynamicfake.FakeDynamicClient
+	propagator *Propagator
+}
+
+func newFixture(t *testing.T, cfg *config.Config, objects ...runtime.Object) *fixture {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objects...)
+	informerFactory := dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+	propagator := NewPropagator(cfg, client, informerFactory, nil)
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	informerFactory.Start(stopCh)
+	for _, informer := range propagator.Informers() {
+		if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+			t.Fatalf("failed to wait for caches to sync")
+		}
+	}
+
+	// Only record the actions of the propagator
+	client.ClearActions()
+
+	return &fixture{
+		t:          t,
+		client:     client,
+		propagator: propagator,
+	}
+}
+
+// sync propagates the labels of the namespace and returns the resulting patch actions.
+func (f *fixture) sync(namespace *corev1.Namespace) []k8stesting.PatchAction {
+	if err := f.propagator.Sync(context.Background(), namespace); err != nil {
+		f.t.Fatalf("failed to sync namespace %s: %v", namespace.Name, err)
+	}
+
+	return f.patches()
+}
+
+// patches returns the patch actions sent to the fake client, failing
+// the test if objects were updated or patched with another patch type.
+func (f *fixture) patches() []k8stesting.PatchAction {
+	patches := []k8stesting.PatchAction{}
+	for _, action := range f.client.Actions() {
+		switch action := action.(type) {
+		case k8stesting.PatchAction:
+			if action.GetPatchType() != types.MergePatchType {
+				f.t.Errorf("unexpected %s patch of %s %s/%s", action.GetPatchType(), action.GetResource().Resource, action.GetNamespace(), action.GetName())
+			}
+			patches = append(patches, action)
+		case k8stesting.UpdateAction:
+			f.t.Errorf("unexpected update of %s in namespace %s", action.GetResource().Resource, action.GetNamespace())
+		}
+	}
+
+	return patches
+}
+
+// labels returns the labels of an object from the fake client.
+func (f *fixture) labels(resource schema.GroupVersionResource, namespace, name string) map[string]string {
+	obj, err := f.client.Resource(resource).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatalf("failed to get %s %s/%s: %v", resource.Resource, namespace, name, err)
+	}
+
+	return obj.GetLabels()
+}
+
+func newNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+func newObject(kind, namespace, name string, labels map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	obj.SetAPIVersion("v1")
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetLabels(labels)
+
+	return obj
+}
+
+// withManagedLabels records that the propagator set the labels of the object.
+func withManagedLabels(obj *unstructured.Unstructured, keys ...string) *unstructured.Unstructured {
+	obj.SetAnnotations(map[string]string{
+		config.ManagedLabelsAnnotation: strings.Join(keys, ","),
+	})
+
+	return obj
+}
+
+func newPod(namespace, name string, labels map[string]string) *unstructured.Unstructured {
+	return newObject("Pod", namespace, name, labels)
+}
+
+func newPVC(namespace, name string, labels map[string]string) *unstructured.Unstructured {
+	return newObject("PersistentVolumeClaim", namespace, name, labels)
+}
+
+func newService(namespace, name string, serviceType corev1.ServiceType) *unstructured.Unstructured {
+	obj := newObject("Service", namespace, name, nil)
+	obj.Object["spec"] = map[string]interface{}{
+		"type": string(serviceType),
+	}
+
+	return obj
+}
+
+// newConfig returns the default configuration restricted to
+// the resources of the core group, served by the fake client.
+func newConfig() *config.Config {
+	cfg := config.Default()
+	cfg.Resources = []config.Resource{}
+	for _, resource := range config.DefaultResources() {
+		if resource.Group == "" {
+			cfg.Resources = append(cfg.Resources, resource)
+		}
+	}
+
+	return cfg
+}
+
+func TestSyncOnlyUpdatesObjectsOfNamespace(t *testing.T) {
+	alpha := newNamespace("alpha", map[string]string{config.WorkloadIDLabel: "alpha-id"})
+
+	f := newFixture(t, newConfig(),
+		newPod("alpha", "pod", nil),
+		newPVC("alpha", "pvc", nil),
+		newPod("beta", "pod", map[string]string{config.WorkloadIDLabel: "stale"}),
+		newPVC("beta", "pvc", map[string]string{config.WorkloadIDLabel: "stale"}),
+	)
+
+	patches := f.sync(alpha)
+	if len(patches) != 2 {
+		t.Fatalf("expected 2 patches, got %d: %v", len(patches), patches)
+	}
+	for _, patch := range patches {
+		if patch.GetNamespace() != "alpha" {
+			t.Errorf("unexpected patch of %s in namespace %s", patch.GetResource().Resource, patch.GetNamespace())
+		}
+	}
+
+	if got := f.labels(podsResource, "alpha", "pod")[config.WorkloadIDLabel]; got != "alpha-id" {
+		t.Errorf("expected pod alpha/pod to have workload-id %q, got %q", "alpha-id", got)
+	}
+	if got := f.labels(pvcsResource, "alpha", "pvc")[config.WorkloadIDLabel]; got != "alpha-id" {
+		t.Errorf("expected pvc alpha/pvc to have workload-id %q, got %q", "alpha-id", got)
+	}
+
+	// The objects of the other namespace keep their labels
+	if got := f.labels(podsResource, "beta", "pod")[config.WorkloadIDLabel]; got != "stale" {
+		t.Errorf("expected pod beta/pod to be untouched, got workload-id %q", got)
+	}
+	if got := f.labels(pvcsResource, "beta", "pvc")[config.WorkloadIDLabel]; got != "stale" {
+		t.Errorf("expected pvc beta/pvc to be untouched, got workload-id %q", got)
+	}
+}
+
+func TestSyncSkipsObjectsWithLabels(t *testing.T) {
+	alpha := newNamespace("alpha", map[string]string{config.WorkloadIDLabel: "alpha-id"})
+
+	f := newFixture(t, newConfig(),
+		withManagedLabels(newPod("alpha", "pod", map[string]string{config.WorkloadIDLabel: "alpha-id", "app": "web"}), config.WorkloadIDLabel),
+		withManagedLabels(newPVC("alpha", "pvc", map[string]string{config.WorkloadIDLabel: "alpha-id"}), config.WorkloadIDLabel),
+	)
+
+	if patches := f.sync(alpha); len(patches) != 0 {
+		t.Errorf("expected no patches, got %d: %v", len(patches), patches)
+	}
+}
+
+func TestSyncWithoutSourceLabel(t *testing.T) {
+	alpha := newNamespace("alpha", nil)
+
+	f := newFixture(t, newConfig(),
+		newPod("alpha", "pod", nil),
+		newPVC("alpha", "pvc", nil),
+	)
+
+	if patches := f.sync(alpha); len(patches) != 0 {
+		t.Errorf("expected no patches, got %d: %v", len(patches), patches)
+	}
+}
+
+func TestSyncConfiguredKinds(t *testing.T) {
+	cfg := newConfig()
+	cfg.Labels = []config.LabelMapping{
+		{
+			Source:      "owner",
+			Destination: "finance.statcan.gc.ca/owner",
+			Default:     "unknown",
+			Kinds:       []string{config.KindPod},
+		},
+	}
+
+	alpha := newNamespace("alpha", nil)
+
+	f := newFixture(t, cfg,
+		newPod("alpha", "pod", nil),
+		newPVC("alpha", "pvc", nil),
+		newPod("beta", "pod", nil),
+	)
+
+	patches := f.sync(alpha)
+	if len(patches) != 1 {
+		t.Fatalf("expected 1 patch, got %d: %v", len(patches), patches)
+	}
+	if resource := patches[0].GetResource().Resource; resource != "pods" {
+		t.Errorf("expected pods to be patched, got %s", resource)
+	}
+	if namespace := patches[0].GetNamespace(); namespace != "alpha" {
+		t.Errorf("unexpected patch of pod in namespace %s", namespace)
+	}
+
+	expected := `{"metadata":{"annotations":{"finance.statcan.gc.ca/managed-labels":"finance.statcan.gc.ca/owner"},"labels":{"finance.statcan.gc.ca/owner":"unknown"}}}`
+	if got := string(patches[0].GetPatch()); got != expected {
+		t.Errorf("expected patch %s, got %s", expected, got)
+	}
+
+	if labels := f.labels(podsResource, "beta", "pod"); len(labels) != 0 {
+		t.Errorf("expected pod beta/pod to be untouched, got labels %v", labels)
+	}
+}
+
+func TestSyncStripsRemovedLabels(t *testing.T) {
+	alpha := newNamespace("alpha", nil)
+
+	f := newFixture(t, newConfig(),
+		withManagedLabels(newPod("alpha", "managed", map[string]string{config.WorkloadIDLabel: "alpha-id", "app": "web"}), config.WorkloadIDLabel),
+		newPod("alpha", "unmanaged", map[string]string{config.WorkloadIDLabel: "manual"}),
+	)
+
+	patches := f.sync(alpha)
+	if len(patches) != 1 {
+		t.Fatalf("expected 1 patch, got %d: %v", len(patches), patches)
+	}
+
+	expected := `{"metadata":{"annotations":{"finance.statcan.gc.ca/managed-labels":null},"labels":{"finance.statcan.gc.ca/workload-id":null}}}`
+	if got := string(patches[0].GetPatch()); got != expected {
+		t.Errorf("expected patch %s, got %s", expected, got)
+	}
+
+	labels := f.labels(podsResource, "alpha", "managed")
+	if _, ok := labels[config.WorkloadIDLabel]; ok {
+		t.Errorf("expected the workload-id of pod alpha/managed to be removed, got labels %v", labels)
+	}
+	if labels["app"] != "web" {
+		t.Errorf("expected the other labels of pod alpha/managed to be kept, got labels %v", labels)
+	}
+
+	// Labels which were not set by the propagator are kept
+	if got := f.labels(podsResource, "alpha", "unmanaged")[config.WorkloadIDLabel]; got != "manual" {
+		t.Errorf("expected pod alpha/unmanaged to be untouched, got workload-id %q", got)
+	}
+}
+
+func TestSyncMatchesFields(t *testing.T) {
+	alpha := newNamespace("alpha", map[string]string{config.WorkloadIDLabel: "alpha-id"})
+
+	f := newFixture(t, newConfig(),
+		newService("alpha", "public", corev1.ServiceTypeLoadBalancer),
+		newService("alpha", "internal", corev1.ServiceTypeClusterIP),
+	)
+
+	patches := f.sync(alpha)
+	if len(patches) != 1 {
+		t.Fatalf("expected 1 patch, got %d: %v", len(patches), patches)
+	}
+	if name := patches[0].GetName(); name != "public" {
+		t.Errorf("expected the load balancer to be patched, got service %s", name)
+	}
+
+	if labels := f.labels(servicesResource, "alpha", "internal"); len(labels) != 0 {
+		t.Errorf("expected service alpha/internal to be untouched, got labels %v", labels)
+	}
+}
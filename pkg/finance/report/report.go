@@ -0,0 +1,277 @@
+// Package report aggregates the resources requested in the namespaces of
+// the cluster by workload, for cost allocation.
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/StatCan/namespace-controller/pkg/finance/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// GPUResourceName is the extended resource requested by pods for GPUs.
+const GPUResourceName corev1.ResourceName = "nvidia.com/gpu"
+
+// Report lists the resources requested by each workload.
+type Report struct {
+	Workloads []*Workload `json:"workloads"`
+}
+
+// Workload is the resources requested in the namespaces of a workload-id.
+type Workload struct {
+	// WorkloadID is the workload-id label of the namespaces,
+	// empty for the namespaces without the label.
+	WorkloadID string   `json:"workloadId"`
+	Namespaces []string `json:"namespaces"`
+
+	// Pods is the number of pods which are not terminated.
+	Pods int `json:"pods"`
+
+	CPU    resource.Quantity `json:"cpu"`
+	Memory resource.Quantity `json:"memory"`
+	GPU    resource.Quantity `json:"gpu"`
+
+	// Storage is the storage requested by the PersistentVolumeClaims, by
+	// storage class. Claims without a storage class are listed under "".
+	Storage map[string]resource.Quantity `json:"storage"`
+}
+
+// Build aggregates the requests of the pods and PersistentVolumeClaims
+// of the namespaces by workload-id. The workloads are sorted by workload-id.
+func Build(namespaces []*corev1.Namespace, pods []*corev1.Pod, pvcs []*corev1.PersistentVolumeClaim) *Report {
+	workloads := map[string]*Workload{}
+	byNamespace := map[string]*Workload{}
+
+	for _, namespace := range namespaces {
+		id := namespace.Labels[config.WorkloadIDLabel]
+
+		workload, ok := workloads[id]
+		if !ok {
+			workload = &Workload{
+				WorkloadID: id,
+				Namespaces: []string{},
+				Storage:    map[string]resource.Quantity{},
+			}
+			workloads[id] = workload
+		}
+
+		workload.Namespaces = append(workload.Namespaces, namespace.Name)
+		byNamespace[namespace.Name] = workload
+	}
+
+	for _, pod := range pods {
+		workload, ok := byNamespace[pod.Namespace]
+		if !ok {
+			continue
+		}
+
+		// Terminated pods no longer hold their requests
+		if IsTerminated(pod) {
+			continue
+		}
+
+		requests := PodRequests(pod)
+		workload.Pods++
+		workload.CPU.Add(requests[corev1.ResourceCPU])
+		workload.Memory.Add(requests[corev1.ResourceMemory])
+		workload.GPU.Add(requests[GPUResourceName])
+	}
+
+	for _, pvc := range pvcs {
+		workload, ok := byNamespace[pvc.Namespace]
+		if !ok {
+			continue
+		}
+
+		storageClass := StorageClass(pvc)
+		storage := workload.Storage[storageClass]
+		storage.Add(pvc.Spec.Resources.Requests[corev1.ResourceStorage])
+		workload.Storage[storageClass] = storage
+	}
+
+	report := &Report{Workloads: []*Workload{}}
+	for _, workload := range workloads {
+		sort.Strings(workload.Namespaces)
+		report.Workloads = append(report.Workloads, workload)
+	}
+	sort.Slice(report.Workloads, func(i, j int) bool {
+		return report.Workloads[i].WorkloadID < report.Workloads[j].WorkloadID
+	})
+
+	return report
+}
+
+// FromListers builds the report from the pods and PersistentVolumeClaims
+// cached by the listers of the finance propagator, which hold unstructured
+// objects. The resources of a nil lister are not reported.
+func FromListers(namespaces []*corev1.Namespace, podLister, pvcLister cache.GenericLister) (*Report, error) {
+	pods := []*corev1.Pod{}
+	if podLister != nil {
+		objs, err := podLister.List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods: %v", err)
+		}
+		for _, obj := range objs {
+			pod := &corev1.Pod{}
+			if err := FromUnstructured(obj, pod); err != nil {
+				return nil, err
+			}
+			pods = append(pods, pod)
+		}
+	}
+
+	pvcs := []*corev1.PersistentVolumeClaim{}
+	if pvcLister != nil {
+		objs, err := pvcLister.List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list persistent volume claims: %v", err)
+		}
+		for _, obj := range objs {
+			pvc := &corev1.PersistentVolumeClaim{}
+			if err := FromUnstructured(obj, pvc); err != nil {
+				return nil, err
+			}
+			pvcs = append(pvcs, pvc)
+		}
+	}
+
+	return Build(namespaces, pods, pvcs), nil
+}
+
+// FromUnstructured converts an unstructured object of a lister to a typed object.
+func FromUnstructured(obj runtime.Object, into interface{}) error {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("expected an unstructured object but got %T", obj)
+	}
+
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, into)
+}
+
+// IsTerminated returns true if the containers of the pod have terminated,
+// in which case the resources of the pod are no longer requested.
+func IsTerminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// PodRequests returns the resources requested by a pod, as computed by the
+// scheduler: the largest of the sum of the containers and of any init
+// container, plus the overhead of the pod.
+func PodRequests(pod *corev1.Pod) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+
+	for _, container := range pod.Spec.Containers {
+		for name, quantity := range container.Resources.Requests {
+			total := requests[name]
+			total.Add(quantity)
+			requests[name] = total
+		}
+	}
+
+	for _, container := range pod.Spec.InitContainers {
+		for name, quantity := range container.Resources.Requests {
+			if total, ok := requests[name]; !ok || quantity.Cmp(total) > 0 {
+				requests[name] = quantity.DeepCopy()
+			}
+		}
+	}
+
+	for name, quantity := range pod.Spec.Overhead {
+		total := requests[name]
+		total.Add(quantity)
+		requests[name] = total
+	}
+
+	return requests
+}
+
+// StorageClass returns the storage class of the claim,
+// or an empty string when the claim has none.
+func StorageClass(pvc *corev1.PersistentVolumeClaim) string {
+	if pvc.Spec.StorageClassName == nil {
+		return ""
+	}
+
+	return *pvc.Spec.StorageClassName
+}
+
+// StorageClasses returns the storage classes requested by the workloads, sorted.
+func (r *Report) StorageClasses() []string {
+	seen := map[string]bool{}
+	classes := []string{}
+	for _, workload := range r.Workloads {
+		for class := range workload.Storage {
+			if !seen[class] {
+				seen[class] = true
+				classes = append(classes, class)
+			}
+		}
+	}
+	sort.Strings(classes)
+
+	return classes
+}
+
+// WriteJSON writes the report as an indented JSON document.
+func (r *Report) WriteJSON(out io.Writer) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(r)
+}
+
+// WriteCSV writes the report as CSV, with a row for each workload.
+// CPUs are written in cores, and memory and storage in bytes. The storage
+// is written in a column for each storage class (storage_bytes for the
+// claims without a storage class), and the namespaces of a workload are
+// separated by semicolons.
+func (r *Report) WriteCSV(out io.Writer) error {
+	classes := r.StorageClasses()
+
+	header := []string{"workload_id", "namespaces", "pods", "cpu_cores", "memory_bytes", "gpu"}
+	for _, class := range classes {
+		if class == "" {
+			header = append(header, "storage_bytes")
+			continue
+		}
+		header = append(header, fmt.Sprintf("storage_bytes_%s", class))
+	}
+
+	w := csv.NewWriter(out)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	for _, workload := range r.Workloads {
+		row := []string{
+			workload.WorkloadID,
+			strings.Join(workload.Namespaces, ";"),
+			strconv.Itoa(workload.Pods),
+			strconv.FormatFloat(float64(workload.CPU.MilliValue())/1000, 'f', -1, 64),
+			strconv.FormatInt(workload.Memory.Value(), 10),
+			strconv.FormatInt(workload.GPU.Value(), 10),
+		}
+		for _, class := range classes {
+			storage := workload.Storage[class]
+			row = append(row, strconv.FormatInt(storage.Value(), 10))
+		}
+
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
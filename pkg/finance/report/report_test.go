@@ -0,0 +1,290 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/StatCan/namespace-controller/pkg/finance/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newNamespace(name, workloadID string) *corev1.Namespace {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if workloadID != "" {
+		namespace.Labels = map[string]string{config.WorkloadIDLabel: workloadID}
+	}
+
+	return namespace
+}
+
+func newPod(namespace string, phase corev1.PodPhase, requests ...corev1.ResourceList) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: namespace},
+		Status:     corev1.PodStatus{Phase: phase},
+	}
+	for _, request := range requests {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Resources: corev1.ResourceRequirements{Requests: request},
+		})
+	}
+
+	return pod
+}
+
+func newPVC(namespace, storageClass, storage string) *corev1.PersistentVolumeClaim {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: namespace},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(storage)},
+			},
+		},
+	}
+	if storageClass != "" {
+		pvc.Spec.StorageClassName = &storageClass
+	}
+
+	return pvc
+}
+
+// summary is the part of a workload compared by the tests.
+type summary struct {
+	Namespaces []string
+	Pods       int
+	CPU        string
+	Memory     string
+	GPU        string
+	Storage    map[string]string
+}
+
+func summarize(r *Report) map[string]summary {
+	summaries := map[string]summary{}
+	for _, workload := range r.Workloads {
+		storage := map[string]string{}
+		for class, quantity := range workload.Storage {
+			storage[class] = quantity.String()
+		}
+
+		summaries[workload.WorkloadID] = summary{
+			Namespaces: workload.Namespaces,
+			Pods:       workload.Pods,
+			CPU:        workload.CPU.String(),
+			Memory:     workload.Memory.String(),
+			GPU:        workload.GPU.String(),
+			Storage:    storage,
+		}
+	}
+
+	return summaries
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces []*corev1.Namespace
+		pods       []*corev1.Pod
+		pvcs       []*corev1.PersistentVolumeClaim
+		expected   map[string]summary
+	}{
+		{
+			name:       "requests of the containers",
+			namespaces: []*corev1.Namespace{newNamespace("alpha", "ws-1"), newNamespace("beta", "ws-1")},
+			pods: []*corev1.Pod{
+				newPod("alpha", corev1.PodRunning,
+					corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+					corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"), GPUResourceName: resource.MustParse("1")},
+				),
+				newPod("beta", corev1.PodPending,
+					corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi"), GPUResourceName: resource.MustParse("2")},
+				),
+			},
+			expected: map[string]summary{
+				"ws-1": {Namespaces: []string{"alpha", "beta"}, Pods: 2, CPU: "1750m", Memory: "2Gi", GPU: "3", Storage: map[string]string{}},
+			},
+		},
+		{
+			name:       "terminated pods",
+			namespaces: []*corev1.Namespace{newNamespace("alpha", "ws-1")},
+			pods: []*corev1.Pod{
+				newPod("alpha", corev1.PodSucceeded, corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}),
+				newPod("alpha", corev1.PodFailed, corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}),
+				newPod("alpha", corev1.PodRunning, corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}),
+			},
+			expected: map[string]summary{
+				"ws-1": {Namespaces: []string{"alpha"}, Pods: 1, CPU: "1", Memory: "0", GPU: "0", Storage: map[string]string{}},
+			},
+		},
+		{
+			name:       "storage by storage class",
+			namespaces: []*corev1.Namespace{newNamespace("alpha", "ws-1"), newNamespace("beta", "")},
+			pvcs: []*corev1.PersistentVolumeClaim{
+				newPVC("alpha", "standard", "10Gi"),
+				newPVC("alpha", "standard", "5Gi"),
+				newPVC("alpha", "premium", "1Gi"),
+				newPVC("alpha", "", "2Gi"),
+				newPVC("beta", "standard", "1Gi"),
+			},
+			expected: map[string]summary{
+				"ws-1": {Namespaces: []string{"alpha"}, CPU: "0", Memory: "0", GPU: "0", Storage: map[string]string{"standard": "15Gi", "premium": "1Gi", "": "2Gi"}},
+				"":     {Namespaces: []string{"beta"}, CPU: "0", Memory: "0", GPU: "0", Storage: map[string]string{"standard": "1Gi"}},
+			},
+		},
+		{
+			name:       "resources of other namespaces",
+			namespaces: []*corev1.Namespace{newNamespace("alpha", "ws-1")},
+			pods:       []*corev1.Pod{newPod("kube-system", corev1.PodRunning, corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")})},
+			pvcs:       []*corev1.PersistentVolumeClaim{newPVC("kube-system", "standard", "1Gi")},
+			expected: map[string]summary{
+				"ws-1": {Namespaces: []string{"alpha"}, CPU: "0", Memory: "0", GPU: "0", Storage: map[string]string{}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := Build(test.namespaces, test.pods, test.pvcs)
+			if summaries := summarize(r); !reflect.DeepEqual(summaries, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, summaries)
+			}
+		})
+	}
+}
+
+func TestPodRequests(t *testing.T) {
+	pod := newPod("alpha", corev1.PodRunning,
+		corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+		corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+	)
+
+	// The largest init container wins over the sum of the containers,
+	// and the overhead of the pod is added
+	pod.Spec.InitContainers = []corev1.Container{
+		{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")}}},
+	}
+	pod.Spec.Overhead = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}
+
+	requests := PodRequests(pod)
+	if cpu := requests[corev1.ResourceCPU]; cpu.String() != "2100m" {
+		t.Errorf("expected 2100m CPUs to be requested, got %s", cpu.String())
+	}
+}
+
+func newTestReport() *Report {
+	return Build(
+		[]*corev1.Namespace{newNamespace("alpha", "ws-1"), newNamespace("beta", "ws-1"), newNamespace("gamma", "")},
+		[]*corev1.Pod{
+			newPod("alpha", corev1.PodRunning, corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m"), corev1.ResourceMemory: resource.MustParse("1Gi"), GPUResourceName: resource.MustParse("1")}),
+		},
+		[]*corev1.PersistentVolumeClaim{
+			newPVC("alpha", "standard", "1Gi"),
+			newPVC("gamma", "", "1Ki"),
+		},
+	)
+}
+
+func TestWriteCSV(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := newTestReport().WriteCSV(out); err != nil {
+		t.Fatalf("failed to write report: %v", err)
+	}
+
+	expected := `workload_id,namespaces,pods,cpu_cores,memory_bytes,gpu,storage_bytes,storage_bytes_standard
+,gamma,0,0,0,0,1024,0
+ws-1,alpha;beta,1,1.5,1073741824,1,0,1073741824
+`
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := newTestReport().WriteJSON(out); err != nil {
+		t.Fatalf("failed to write report: %v", err)
+	}
+
+	var got interface{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"workloads": []interface{}{
+			map[string]interface{}{
+				"workloadId": "",
+				"namespaces": []interface{}{"gamma"},
+				"pods":       0.0,
+				"cpu":        "0",
+				"memory":     "0",
+				"gpu":        "0",
+				"storage":    map[string]interface{}{"": "1Ki"},
+			},
+			map[string]interface{}{
+				"workloadId": "ws-1",
+				"namespaces": []interface{}{"alpha", "beta"},
+				"pods":       1.0,
+				"cpu":        "1500m",
+				"memory":     "1Gi",
+				"gpu":        "1",
+				"storage":    map[string]interface{}{"standard": "1Gi"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+// newLister returns a lister of the objects, converted to unstructured
+// objects as in the listers of the propagator.
+func newLister(t *testing.T, resource string, objects ...runtime.Object) cache.GenericLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, obj := range objects {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			t.Fatalf("failed to convert object: %v", err)
+		}
+		if err := indexer.Add(&unstructured.Unstructured{Object: content}); err != nil {
+			t.Fatalf("failed to add object: %v", err)
+		}
+	}
+
+	return cache.NewGenericLister(indexer, schema.GroupResource{Resource: resource})
+}
+
+func TestFromListers(t *testing.T) {
+	namespaces := []*corev1.Namespace{newNamespace("alpha", "ws-1")}
+	pod := newPod("alpha", corev1.PodRunning, corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")})
+	pvc := newPVC("alpha", "standard", "1Gi")
+
+	r, err := FromListers(namespaces, newLister(t, "pods", pod), newLister(t, "persistentvolumeclaims", pvc))
+	if err != nil {
+		t.Fatalf("failed to build report: %v", err)
+	}
+	expected := map[string]summary{
+		"ws-1": {Namespaces: []string{"alpha"}, Pods: 1, CPU: "1", Memory: "0", GPU: "0", Storage: map[string]string{"standard": "1Gi"}},
+	}
+	if summaries := summarize(r); !reflect.DeepEqual(summaries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, summaries)
+	}
+
+	// The resources without a lister are not reported
+	r, err = FromListers(namespaces, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to build report: %v", err)
+	}
+	expected = map[string]summary{
+		"ws-1": {Namespaces: []string{"alpha"}, CPU: "0", Memory: "0", GPU: "0", Storage: map[string]string{}},
+	}
+	if summaries := summarize(r); !reflect.DeepEqual(summaries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, summaries)
+	}
+}
@@ -0,0 +1,218 @@
+package validation
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Allowlist maps the valid workload-ids to the time they expire.
+// The zero time is used for the workload-ids which do not expire.
+type Allowlist map[string]time.Time
+
+// Source loads the allowlist of workload-ids.
+type Source interface {
+	// Load returns the current allowlist.
+	Load(ctx context.Context) (Allowlist, error)
+
+	// String describes the source in logs.
+	String() string
+}
+
+// Entry is a workload-id of a JSON allowlist.
+type Entry struct {
+	WorkloadID string `json:"workloadId"`
+
+	// Expires is the date (2006-01-02) or time (RFC 3339) at which
+	// the workload-id expires. The workload-id does not expire when empty.
+	Expires string `json:"expires,omitempty"`
+}
+
+// FileSource loads the allowlist from a CSV or JSON file,
+// depending on the extension of the file.
+type FileSource struct {
+	Path string
+}
+
+// Load implements Source.
+func (s *FileSource) Load(ctx context.Context) (Allowlist, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read allowlist file %q: %w", s.Path, err)
+	}
+
+	return parse(s.Path, data)
+}
+
+func (s *FileSource) String() string {
+	return "file " + s.Path
+}
+
+// ConfigMapSource loads the allowlist from a key of a ConfigMap,
+// as CSV or JSON depending on the extension of the key.
+type ConfigMapSource struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+	Key       string
+}
+
+// Load implements Source.
+func (s *ConfigMapSource) Load(ctx context.Context) (Allowlist, error) {
+	configMap, err := s.Client.CoreV1().ConfigMaps(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get allowlist ConfigMap %s/%s: %w", s.Namespace, s.Name, err)
+	}
+
+	data, ok := configMap.Data[s.Key]
+	if !ok {
+		return nil, fmt.Errorf("allowlist ConfigMap %s/%s has no key %q", s.Namespace, s.Name, s.Key)
+	}
+
+	return parse(s.Key, []byte(data))
+}
+
+func (s *ConfigMapSource) String() string {
+	return fmt.Sprintf("ConfigMap %s/%s (%s)", s.Namespace, s.Name, s.Key)
+}
+
+// HTTPSource loads the allowlist from an HTTP endpoint returning
+// a JSON array of entries.
+type HTTPSource struct {
+	URL    string
+	Client *http.Client
+}
+
+// Load implements Source.
+func (s *HTTPSource) Load(ctx context.Context) (Allowlist, error) {
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: time.Second * 30}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request allowlist from %s: %w", s.URL, err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request allowlist from %s: %w", s.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to request allowlist from %s: %s", s.URL, resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read allowlist from %s: %w", s.URL, err)
+	}
+
+	return ParseJSON(data)
+}
+
+func (s *HTTPSource) String() string {
+	return s.URL
+}
+
+// parse parses the allowlist as JSON when name ends in .json, and as CSV otherwise.
+func parse(name string, data []byte) (Allowlist, error) {
+	if strings.HasSuffix(name, ".json") {
+		return ParseJSON(data)
+	}
+
+	return ParseCSV(data)
+}
+
+// ParseCSV parses an allowlist with a workload-id and an optional expiry
+// per line. A header whose first column is workload_id is skipped, as are
+// lines starting with #.
+func ParseCSV(data []byte) (Allowlist, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	allowlist := Allowlist{}
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse allowlist: %w", err)
+		}
+
+		if first && record[0] == "workload_id" {
+			continue
+		}
+
+		entry := Entry{WorkloadID: record[0]}
+		if len(record) > 1 {
+			entry.Expires = record[1]
+		}
+		if err := allowlist.add(entry); err != nil {
+			return nil, err
+		}
+	}
+
+	return allowlist, nil
+}
+
+// ParseJSON parses an allowlist given as a JSON array of entries.
+func ParseJSON(data []byte) (Allowlist, error) {
+	entries := []Entry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse allowlist: %w", err)
+	}
+
+	allowlist := Allowlist{}
+	for _, entry := range entries {
+		if err := allowlist.add(entry); err != nil {
+			return nil, err
+		}
+	}
+
+	return allowlist, nil
+}
+
+func (a Allowlist) add(entry Entry) error {
+	id := strings.TrimSpace(entry.WorkloadID)
+	if id == "" {
+		return fmt.Errorf("allowlist contains an empty workload-id")
+	}
+
+	expires, err := parseExpiry(strings.TrimSpace(entry.Expires))
+	if err != nil {
+		return fmt.Errorf("invalid expiry of workload-id %q: %w", id, err)
+	}
+
+	a[id] = expires
+	return nil
+}
+
+// parseExpiry parses a date or a time. A workload-id expiring on a date
+// remains valid until the end of that day (UTC).
+func parseExpiry(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date.Add(time.Hour * 24), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,163 @@
+package validation
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var expectedAllowlist = Allowlist{
+	"alpha-id": {},
+	"beta-id":  time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+	"gamma-id": time.Date(2021, 6, 30, 12, 0, 0, 0, time.UTC),
+}
+
+const csvAllowlist = `workload_id,expires
+# Comments are ignored
+alpha-id,
+beta-id, 2021-12-31
+gamma-id,2021-06-30T12:00:00Z,extra columns are ignored
+`
+
+const jsonAllowlist = `[
+  {"workloadId": "alpha-id"},
+  {"workloadId": "beta-id", "expires": "2021-12-31"},
+  {"workloadId": "gamma-id", "expires": "2021-06-30T12:00:00Z"}
+]`
+
+func assertAllowlist(t *testing.T, allowlist Allowlist) {
+	t.Helper()
+
+	if len(allowlist) != len(expectedAllowlist) {
+		t.Fatalf("expected %d workload-ids, got %v", len(expectedAllowlist), allowlist)
+	}
+	for id, expires := range expectedAllowlist {
+		if got, ok := allowlist[id]; !ok || !got.Equal(expires) {
+			t.Errorf("expected %s to expire at %v, got %v", id, expires, got)
+		}
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	allowlist, err := ParseCSV([]byte(csvAllowlist))
+	if err != nil {
+		t.Fatalf("failed to parse allowlist: %v", err)
+	}
+
+	assertAllowlist(t, allowlist)
+}
+
+func TestParseJSON(t *testing.T) {
+	allowlist, err := ParseJSON([]byte(jsonAllowlist))
+	if err != nil {
+		t.Fatalf("failed to parse allowlist: %v", err)
+	}
+
+	assertAllowlist(t, allowlist)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func([]byte) (Allowlist, error)
+		data  string
+		err   string
+	}{
+		{"empty workload-id", ParseCSV, "alpha-id\n,2021-12-31\n", "empty workload-id"},
+		{"invalid expiry", ParseCSV, "alpha-id,tomorrow\n", `invalid expiry of workload-id "alpha-id"`},
+		{"invalid json", ParseJSON, `{"workloadId": "alpha-id"}`, "failed to parse allowlist"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.parse([]byte(test.data))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{"allowlist.csv": csvAllowlist, "allowlist.json": jsonAllowlist} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("failed to write allowlist: %v", err)
+		}
+
+		allowlist, err := (&FileSource{Path: path}).Load(context.Background())
+		if err != nil {
+			t.Fatalf("failed to load %s: %v", name, err)
+		}
+		assertAllowlist(t, allowlist)
+	}
+}
+
+func TestConfigMapSource(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-controller", Name: "allowlist"},
+		Data: map[string]string{
+			"workload-ids.csv":  csvAllowlist,
+			"workload-ids.json": jsonAllowlist,
+		},
+	})
+
+	for _, key := range []string{"workload-ids.csv", "workload-ids.json"} {
+		allowlist, err := (&ConfigMapSource{Client: client, Namespace: "namespace-controller", Name: "allowlist", Key: key}).Load(context.Background())
+		if err != nil {
+			t.Fatalf("failed to load %s: %v", key, err)
+		}
+		assertAllowlist(t, allowlist)
+	}
+
+	if _, err := (&ConfigMapSource{Client: client, Namespace: "namespace-controller", Name: "allowlist", Key: "missing"}).Load(context.Background()); err == nil {
+		t.Errorf("expected an error for a missing key")
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/workload-ids" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(jsonAllowlist))
+	}))
+	defer server.Close()
+
+	allowlist, err := (&HTTPSource{URL: server.URL + "/workload-ids"}).Load(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load allowlist: %v", err)
+	}
+	assertAllowlist(t, allowlist)
+
+	_, err = (&HTTPSource{URL: server.URL + "/missing"}).Load(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a 404 error, got %v", err)
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	expires, err := parseExpiry("2021-12-31")
+	if err != nil {
+		t.Fatalf("failed to parse expiry: %v", err)
+	}
+
+	// A workload-id expiring on a date is valid during that day
+	expected := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !reflect.DeepEqual(expires, expected) {
+		t.Errorf("expected %v, got %v", expected, expires)
+	}
+}
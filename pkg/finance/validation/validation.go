@@ -0,0 +1,242 @@
+// Package validation checks the workload-id of the namespaces against
+// an allowlist of the active billing codes, reporting the namespaces
+// with an unknown or expired workload-id.
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/StatCan/namespace-controller/pkg/dryrun"
+	"github.com/StatCan/namespace-controller/pkg/finance/config"
+	"github.com/StatCan/namespace-controller/pkg/finance/propagation"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+// ConditionAnnotation holds the WorkloadIDValid condition of a namespace,
+// serialized as JSON.
+const ConditionAnnotation = "finance.statcan.gc.ca/workload-id-condition"
+
+// ConditionType is the type of the condition reporting whether the
+// workload-id of the namespace is valid.
+const ConditionType = "WorkloadIDValid"
+
+// Reasons of the WorkloadIDValid condition
+const (
+	ReasonValid   = "Valid"
+	ReasonUnknown = "Unknown"
+	ReasonExpired = "Expired"
+)
+
+// Validator validates the workload-id of the namespaces against the
+// allowlist loaded from its source.
+type Validator struct {
+	source     Source
+	kubeClient kubernetes.Interface
+	recorder   record.EventRecorder
+	changes    *dryrun.Recorder
+
+	// onChange is called when a refresh changes the allowlist
+	onChange func()
+
+	// now returns the current time, and is replaced by the tests
+	now func() time.Time
+
+	mu        sync.RWMutex
+	allowlist Allowlist
+}
+
+// NewValidator creates a validator loading the allowlist from source.
+// Refresh must be called before validating namespaces.
+func NewValidator(source Source, kubeClient kubernetes.Interface, recorder record.EventRecorder, changes *dryrun.Recorder, onChange func()) *Validator {
+	return &Validator{
+		source:     source,
+		kubeClient: kubeClient,
+		recorder:   recorder,
+		changes:    changes,
+		onChange:   onChange,
+		now:        time.Now,
+	}
+}
+
+// Refresh loads the allowlist from the source, calling the change
+// callback when it differs from the current allowlist.
+func (v *Validator) Refresh(ctx context.Context) error {
+	allowlist, err := v.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	changed := v.allowlist != nil && !reflect.DeepEqual(v.allowlist, allowlist)
+	v.allowlist = allowlist
+	v.mu.Unlock()
+
+	if changed {
+		klog.Infof("workload-id allowlist changed (%d workload-ids)", len(allowlist))
+		if v.onChange != nil {
+			v.onChange()
+		}
+	}
+
+	return nil
+}
+
+// Run refreshes the allowlist every interval until ctx is cancelled.
+// The previous allowlist is kept when the source is unavailable.
+func (v *Validator) Run(ctx context.Context, interval time.Duration) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := v.Refresh(ctx); err != nil {
+			klog.Errorf("failed to refresh the workload-id allowlist from %s: %v", v.source, err)
+		}
+	}, interval)
+}
+
+// Validate returns the condition of the workload-id.
+func (v *Validator) Validate(workloadID string) metav1.Condition {
+	v.mu.RLock()
+	expires, ok := v.allowlist[workloadID]
+	v.mu.RUnlock()
+
+	condition := metav1.Condition{
+		Type:    ConditionType,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonValid,
+		Message: fmt.Sprintf("Workload-id %s is valid", workloadID),
+	}
+
+	if !ok {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonUnknown
+		condition.Message = fmt.Sprintf("Workload-id %s is not a known billing code", workloadID)
+	} else if !expires.IsZero() && !v.now().Before(expires) {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonExpired
+		condition.Message = fmt.Sprintf("Workload-id %s expired on %s", workloadID, expires.UTC().Format(time.RFC3339))
+	}
+
+	return condition
+}
+
+// Sync validates the workload-id of the namespace and records the result
+// in its condition annotation, recording a warning when the workload-id
+// becomes invalid. It returns false when the workload-id is invalid.
+// Namespaces without a workload-id are not validated.
+func (v *Validator) Sync(ctx context.Context, namespace *corev1.Namespace) (bool, error) {
+	workloadID, ok := namespace.Labels[config.WorkloadIDLabel]
+	current := GetCondition(namespace)
+
+	var desired *metav1.Condition
+	if ok {
+		condition := v.Validate(workloadID)
+		desired = &condition
+	}
+
+	valid := desired == nil || desired.Status == metav1.ConditionTrue
+
+	if current == nil && desired == nil {
+		return valid, nil
+	}
+	if current != nil && desired != nil && current.Status == desired.Status && current.Reason == desired.Reason && current.Message == desired.Message {
+		return valid, nil
+	}
+
+	var value *string
+	if desired != nil {
+		// Keep the transition time unless the status changes
+		desired.LastTransitionTime = metav1.NewTime(v.now())
+		if current != nil && current.Status == desired.Status {
+			desired.LastTransitionTime = current.LastTransitionTime
+		}
+
+		data, err := json.Marshal(desired)
+		if err != nil {
+			return valid, err
+		}
+		annotation := string(data)
+		value = &annotation
+	}
+
+	if err := v.updateCondition(ctx, namespace, value); err != nil {
+		return valid, err
+	}
+
+	if !valid && v.changes == nil && v.recorder != nil {
+		v.recorder.Eventf(namespace, corev1.EventTypeWarning, "WorkloadID"+desired.Reason, "%s", desired.Message)
+	}
+
+	return valid, nil
+}
+
+// updateCondition sets the condition annotation of the namespace to value,
+// removing it when value is nil.
+func (v *Validator) updateCondition(ctx context.Context, namespace *corev1.Namespace, value *string) error {
+	updated := namespace.DeepCopy()
+	if value != nil {
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[ConditionAnnotation] = *value
+	} else {
+		delete(updated.Annotations, ConditionAnnotation)
+	}
+
+	if err := v.changes.Record(dryrun.Update, "namespaces", "", namespace.Name, namespace, updated); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				ConditionAnnotation: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = v.kubeClient.CoreV1().Namespaces().Patch(ctx, namespace.Name, types.MergePatchType, data, metav1.PatchOptions{
+		FieldManager: propagation.FieldManager,
+		DryRun:       dryrun.Options(v.changes != nil),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to patch namespace %s: %w", namespace.Name, err)
+	}
+
+	return nil
+}
+
+// GetCondition returns the WorkloadIDValid condition recorded on the
+// namespace, or nil when it is missing or invalid.
+func GetCondition(namespace *corev1.Namespace) *metav1.Condition {
+	value, ok := namespace.Annotations[ConditionAnnotation]
+	if !ok {
+		return nil
+	}
+
+	condition := &metav1.Condition{}
+	if err := json.Unmarshal([]byte(value), condition); err != nil {
+		klog.Warningf("invalid %s annotation on namespace <%s>: %v", ConditionAnnotation, namespace.Name, err)
+		return nil
+	}
+
+	return condition
+}
+
+// IsInvalid returns true when the condition recorded on the namespace
+// reports that its workload-id is invalid.
+func IsInvalid(namespace *corev1.Namespace) bool {
+	condition := GetCondition(namespace)
+	return condition != nil && condition.Status == metav1.ConditionFalse
+}
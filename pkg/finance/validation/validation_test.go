@@ -0,0 +1,218 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/StatCan/namespace-controller/pkg/finance/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// staticSource is a source returning a fixed allowlist.
+type staticSource struct {
+	allowlist Allowlist
+	err       error
+}
+
+func (s *staticSource) Load(ctx context.Context) (Allowlist, error) {
+	return s.allowlist, s.err
+}
+
+func (s *staticSource) String() string {
+	return "static"
+}
+
+var testNow = time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+
+func newNamespace(workloadID string, annotations map[string]string) *corev1.Namespace {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "alpha",
+			Labels:      map[string]string{},
+			Annotations: annotations,
+		},
+	}
+	if workloadID != "" {
+		namespace.Labels[config.WorkloadIDLabel] = workloadID
+	}
+
+	return namespace
+}
+
+func newTestValidator(t *testing.T, namespace *corev1.Namespace) (*Validator, *fake.Clientset, *record.FakeRecorder) {
+	client := fake.NewSimpleClientset(namespace)
+	recorder := record.NewFakeRecorder(10)
+
+	validator := NewValidator(&staticSource{allowlist: expectedAllowlist}, client, recorder, nil, nil)
+	validator.now = func() time.Time { return testNow }
+	if err := validator.Refresh(context.Background()); err != nil {
+		t.Fatalf("failed to load allowlist: %v", err)
+	}
+
+	// Only record the actions of the validator
+	client.ClearActions()
+
+	return validator, client, recorder
+}
+
+func TestValidate(t *testing.T) {
+	validator, _, _ := newTestValidator(t, newNamespace("", nil))
+
+	tests := []struct {
+		workloadID string
+		status     metav1.ConditionStatus
+		reason     string
+	}{
+		{"alpha-id", metav1.ConditionTrue, ReasonValid},
+		{"beta-id", metav1.ConditionTrue, ReasonValid},
+		{"gamma-id", metav1.ConditionFalse, ReasonExpired},
+		{"delta-id", metav1.ConditionFalse, ReasonUnknown},
+	}
+
+	for _, test := range tests {
+		condition := validator.Validate(test.workloadID)
+		if condition.Type != ConditionType || condition.Status != test.status || condition.Reason != test.reason {
+			t.Errorf("expected %s to be %s (%s), got %s (%s)", test.workloadID, test.status, test.reason, condition.Status, condition.Reason)
+		}
+	}
+}
+
+func TestSyncInvalid(t *testing.T) {
+	namespace := newNamespace("delta-id", nil)
+	validator, client, recorder := newTestValidator(t, namespace)
+
+	valid, err := validator.Sync(context.Background(), namespace)
+	if err != nil {
+		t.Fatalf("failed to sync namespace: %v", err)
+	}
+	if valid {
+		t.Errorf("expected the unknown workload-id to be invalid")
+	}
+
+	updated, err := client.CoreV1().Namespaces().Get(context.Background(), "alpha", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get namespace: %v", err)
+	}
+	if !IsInvalid(updated) {
+		t.Errorf("expected the condition to be recorded, got annotations %v", updated.Annotations)
+	}
+	condition := GetCondition(updated)
+	if condition.Reason != ReasonUnknown || !condition.LastTransitionTime.Time.Equal(testNow) {
+		t.Errorf("unexpected condition %+v", condition)
+	}
+
+	select {
+	case event := <-recorder.Events:
+		expected := "Warning WorkloadIDUnknown Workload-id delta-id is not a known billing code"
+		if event != expected {
+			t.Errorf("expected event %q, got %q", expected, event)
+		}
+	default:
+		t.Errorf("expected a warning event")
+	}
+
+	// The condition and the event are only recorded when the condition changes
+	client.ClearActions()
+	valid, err = validator.Sync(context.Background(), updated)
+	if err != nil {
+		t.Fatalf("failed to sync namespace: %v", err)
+	}
+	if valid {
+		t.Errorf("expected the unknown workload-id to remain invalid")
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("expected no changes, got %v", actions)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("expected no further events, got %d", len(recorder.Events))
+	}
+}
+
+func TestSyncValid(t *testing.T) {
+	// The transition time is kept when the status does not change
+	transition := metav1.NewTime(testNow.Add(-time.Hour))
+	annotation := fmt.Sprintf(`{"type":%q,"status":"True","reason":"Valid","message":"Workload-id beta-id is valid","lastTransitionTime":%q}`, ConditionType, transition.UTC().Format(time.RFC3339))
+
+	namespace := newNamespace("alpha-id", map[string]string{ConditionAnnotation: annotation})
+	validator, client, recorder := newTestValidator(t, namespace)
+
+	valid, err := validator.Sync(context.Background(), namespace)
+	if err != nil {
+		t.Fatalf("failed to sync namespace: %v", err)
+	}
+	if !valid {
+		t.Errorf("expected the workload-id to be valid")
+	}
+
+	updated, err := client.CoreV1().Namespaces().Get(context.Background(), "alpha", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get namespace: %v", err)
+	}
+	condition := GetCondition(updated)
+	if condition == nil || condition.Message != "Workload-id alpha-id is valid" || !condition.LastTransitionTime.Equal(&transition) {
+		t.Errorf("unexpected condition %+v", condition)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("expected no events for a valid workload-id, got %d", len(recorder.Events))
+	}
+}
+
+func TestSyncWithoutWorkloadID(t *testing.T) {
+	namespace := newNamespace("", map[string]string{ConditionAnnotation: `{"type":"WorkloadIDValid","status":"False"}`})
+	validator, client, _ := newTestValidator(t, namespace)
+
+	valid, err := validator.Sync(context.Background(), namespace)
+	if err != nil {
+		t.Fatalf("failed to sync namespace: %v", err)
+	}
+	if !valid {
+		t.Errorf("expected namespaces without a workload-id not to be validated")
+	}
+
+	// The condition of a removed workload-id is removed
+	updated, err := client.CoreV1().Namespaces().Get(context.Background(), "alpha", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get namespace: %v", err)
+	}
+	if _, ok := updated.Annotations[ConditionAnnotation]; ok {
+		t.Errorf("expected the condition to be removed, got annotations %v", updated.Annotations)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	source := &staticSource{allowlist: Allowlist{"alpha-id": {}}}
+	changes := 0
+	validator := NewValidator(source, fake.NewSimpleClientset(), nil, nil, func() { changes++ })
+
+	if err := validator.Refresh(context.Background()); err != nil {
+		t.Fatalf("failed to load allowlist: %v", err)
+	}
+	if err := validator.Refresh(context.Background()); err != nil {
+		t.Fatalf("failed to load allowlist: %v", err)
+	}
+	if changes != 0 {
+		t.Errorf("expected the initial and unchanged allowlists not to be reported, got %d changes", changes)
+	}
+
+	source.allowlist = Allowlist{"alpha-id": {}, "beta-id": {}}
+	if err := validator.Refresh(context.Background()); err != nil {
+		t.Fatalf("failed to load allowlist: %v", err)
+	}
+	if changes != 1 {
+		t.Errorf("expected the change to be reported, got %d changes", changes)
+	}
+
+	// The allowlist is kept when the source fails
+	source.err = fmt.Errorf("unavailable")
+	if err := validator.Refresh(context.Background()); err == nil {
+		t.Errorf("expected the error of the source")
+	}
+	if condition := validator.Validate("beta-id"); condition.Status != metav1.ConditionTrue {
+		t.Errorf("expected the previous allowlist to be kept, got %+v", condition)
+	}
+}
@@ -0,0 +1,295 @@
+// Package webhook implements the mutating admission webhook of the finance
+// controller, which sets the finance labels of a namespace on the resources
+// created in the namespace, before they are persisted.
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StatCan/namespace-controller/pkg/finance/config"
+	"github.com/StatCan/namespace-controller/pkg/server"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+)
+
+// Path is the path serving the admission reviews.
+const Path = "/mutate"
+
+// Handler mutates the resources created in a namespace so that they carry
+// the labels propagated from the namespace.
+type Handler struct {
+	config          *config.Config
+	namespaceLister corev1listers.NamespaceLister
+
+	// skip returns true for the namespaces which are not managed
+	skip func(*corev1.Namespace) bool
+
+	// dryRun logs the mutations instead of returning them
+	dryRun bool
+}
+
+// NewHandler creates a webhook handler for the label mappings of the configuration.
+func NewHandler(cfg *config.Config, namespaceLister corev1listers.NamespaceLister, skip func(*corev1.Namespace) bool, dryRun bool) *Handler {
+	return &Handler{
+		config:          cfg,
+		namespaceLister: namespaceLister,
+		skip:            skip,
+		dryRun:          dryRun,
+	}
+}
+
+// jsonPatchOperation is an operation of a JSON patch (RFC 6902).
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "expected a POST request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("failed to decode admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review.Response = h.admit(review.Request)
+	review.Response.UID = review.Request.UID
+
+	response, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode admission review: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// admit returns the patch setting the labels of the namespace on the
+// created object. Objects are always admitted: if the labels cannot be
+// determined, the controller labels the object once it is created.
+func (h *Handler) admit(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	response := &admissionv1.AdmissionResponse{Allowed: true}
+
+	if req.Operation != admissionv1.Create {
+		return response
+	}
+
+	namespace, err := h.namespaceLister.Get(req.Namespace)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("failed loading namespace <%s> for %s %s: %v", req.Namespace, req.Kind.Kind, req.Name, err)
+		}
+		return response
+	}
+
+	if h.skip != nil && h.skip(namespace) {
+		return response
+	}
+
+	desired := h.config.LabelsFor(namespace.Labels, req.Kind.Kind)
+	if len(desired) == 0 {
+		return response
+	}
+
+	obj := &metav1.PartialObjectMetadata{}
+	if err := json.Unmarshal(req.Object.Raw, obj); err != nil {
+		klog.Errorf("failed decoding %s in namespace <%s>: %v", req.Kind.Kind, req.Namespace, err)
+		return response
+	}
+
+	// Record the propagated labels, so that the controller removes them
+	// once they are no longer propagated
+	patch := mapPatch("/metadata/labels", obj.Labels, desired)
+	patch = append(patch, mapPatch("/metadata/annotations", obj.Annotations, map[string]string{
+		config.ManagedLabelsAnnotation: config.FormatManagedLabels(desired),
+	})...)
+	if len(patch) == 0 {
+		return response
+	}
+
+	// Objects created from a generated name have no name yet
+	name := obj.Name
+	if name == "" {
+		name = obj.GenerateName + "*"
+	}
+
+	if h.dryRun {
+		klog.Infof("dry-run: would set labels %v on %s %s/%s", desired, req.Kind.Kind, req.Namespace, name)
+		return response
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		klog.Errorf("failed encoding patch of %s %s/%s: %v", req.Kind.Kind, req.Namespace, name, err)
+		return response
+	}
+
+	klog.Infof("setting namespace <%s> labels on %s %s", req.Namespace, req.Kind.Kind, name)
+	patchType := admissionv1.PatchTypeJSONPatch
+	response.Patch = data
+	response.PatchType = &patchType
+
+	return response
+}
+
+// mapPatch returns the JSON patch operations setting the desired entries
+// of the labels or annotations at path which differ from the current entries.
+func mapPatch(path string, current, desired map[string]string) []jsonPatchOperation {
+	patch := []jsonPatchOperation{}
+
+	if current == nil {
+		return append(patch, jsonPatchOperation{
+			Op:    "add",
+			Path:  path,
+			Value: desired,
+		})
+	}
+
+	for key, value := range desired {
+		if existing, ok := current[key]; ok && existing == value {
+			continue
+		}
+
+		patch = append(patch, jsonPatchOperation{
+			Op:    "add",
+			Path:  path + "/" + escapeJSONPointer(key),
+			Value: value,
+		})
+	}
+
+	return patch
+}
+
+// escapeJSONPointer escapes a reference token of a JSON pointer (RFC 6901).
+func escapeJSONPointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// Serve serves the webhook over TLS on addr until ctx is cancelled.
+// The certificate is reloaded when its files change, so that rotated
+// certificates are served without a restart.
+func Serve(ctx context.Context, addr, certFile, keyFile string, handler http.Handler) error {
+	certificate := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := certificate.load(); err != nil {
+		return fmt.Errorf("failed to load webhook certificate: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(Path, handler)
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+		TLSConfig: &tls.Config{
+			GetCertificate: certificate.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		},
+	}
+
+	server.ShutdownOnDone(ctx, srv)
+
+	klog.Infof("serving admission webhook on %s%s", addr, Path)
+	if err := srv.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// certificateReloader loads the key pair of the webhook again when the
+// modification time of its files changes (e.g., when the Secret mounted
+// in the pod is updated).
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	modTime     time.Time
+}
+
+// GetCertificate returns the current certificate, reloading it first when
+// its files changed. The previous certificate is kept when the files cannot
+// be loaded, as they may be in the middle of an update.
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		klog.Errorf("failed to check webhook certificate: %v", err)
+	} else if !modTime.Equal(r.modTime) {
+		if err := r.loadLocked(modTime); err != nil {
+			klog.Errorf("failed to reload webhook certificate: %v", err)
+		} else {
+			klog.Infof("reloaded webhook certificate %s", r.certFile)
+		}
+	}
+
+	return r.certificate, nil
+}
+
+// load loads the key pair.
+func (r *certificateReloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	return r.loadLocked(modTime)
+}
+
+func (r *certificateReloader) loadLocked(modTime time.Time) error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.certificate = &certificate
+	r.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the latest modification time of the key pair files.
+func (r *certificateReloader) latestModTime() (time.Time, error) {
+	latest := time.Time{}
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
@@ -0,0 +1,296 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/StatCan/namespace-controller/pkg/finance/config"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestHandler(t *testing.T, dryRun bool) *Handler {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	namespaces := []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "alpha", Labels: map[string]string{config.WorkloadIDLabel: "ws-1"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "skipped", Labels: map[string]string{config.WorkloadIDLabel: "ws-2", "control-plane": "true"}}},
+	}
+	for _, namespace := range namespaces {
+		if err := indexer.Add(namespace); err != nil {
+			t.Fatalf("failed to add namespace: %v", err)
+		}
+	}
+
+	skip := func(namespace *corev1.Namespace) bool {
+		_, ok := namespace.Labels["control-plane"]
+		return ok
+	}
+
+	return NewHandler(config.Default(), corev1listers.NewNamespaceLister(indexer), skip, dryRun)
+}
+
+// review sends an admission review for the pod to the handler and returns
+// its response.
+func review(t *testing.T, handler http.Handler, operation admissionv1.Operation, pod *corev1.Pod) *admissionv1.AdmissionResponse {
+	t.Helper()
+
+	request := &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "test-uid",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			Operation: operation,
+			Object:    runtime.RawExtension{Object: pod},
+		},
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("failed to encode admission review: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, Path, bytes.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	response := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatalf("failed to decode admission review: %v", err)
+	}
+	if response.Response == nil || !response.Response.Allowed || response.Response.UID != "test-uid" {
+		t.Fatalf("expected the request to be allowed, got %+v", response.Response)
+	}
+
+	return response.Response
+}
+
+func TestAdmit(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		pod       *corev1.Pod
+		dryRun    bool
+		expected  []jsonPatchOperation
+	}{
+		{
+			name:      "create",
+			operation: admissionv1.Create,
+			pod:       &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "alpha"}},
+			expected: []jsonPatchOperation{
+				{Op: "add", Path: "/metadata/labels", Value: map[string]interface{}{config.WorkloadIDLabel: "ws-1"}},
+				{Op: "add", Path: "/metadata/annotations", Value: map[string]interface{}{config.ManagedLabelsAnnotation: config.WorkloadIDLabel}},
+			},
+		},
+		{
+			name:      "create with labels",
+			operation: admissionv1.Create,
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:        "pod",
+				Namespace:   "alpha",
+				Labels:      map[string]string{"app": "pod"},
+				Annotations: map[string]string{"note": "pod"},
+			}},
+			expected: []jsonPatchOperation{
+				{Op: "add", Path: "/metadata/labels/finance.statcan.gc.ca~1workload-id", Value: "ws-1"},
+				{Op: "add", Path: "/metadata/annotations/finance.statcan.gc.ca~1managed-labels", Value: config.WorkloadIDLabel},
+			},
+		},
+		{
+			name:      "create with the labels",
+			operation: admissionv1.Create,
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:        "pod",
+				Namespace:   "alpha",
+				Labels:      map[string]string{config.WorkloadIDLabel: "ws-1"},
+				Annotations: map[string]string{config.ManagedLabelsAnnotation: config.WorkloadIDLabel},
+			}},
+		},
+		{
+			name:      "update",
+			operation: admissionv1.Update,
+			pod:       &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "alpha"}},
+		},
+		{
+			name:      "skipped namespace",
+			operation: admissionv1.Create,
+			pod:       &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "skipped"}},
+		},
+		{
+			name:      "missing namespace",
+			operation: admissionv1.Create,
+			pod:       &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "missing"}},
+		},
+		{
+			name:      "dry-run",
+			operation: admissionv1.Create,
+			pod:       &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "alpha"}},
+			dryRun:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := review(t, newTestHandler(t, test.dryRun), test.operation, test.pod)
+
+			if test.expected == nil {
+				if response.Patch != nil || response.PatchType != nil {
+					t.Errorf("expected no patch, got %s", response.Patch)
+				}
+				return
+			}
+
+			if response.PatchType == nil || *response.PatchType != admissionv1.PatchTypeJSONPatch {
+				t.Errorf("expected a JSON patch, got %v", response.PatchType)
+			}
+
+			patch := []jsonPatchOperation{}
+			if err := json.Unmarshal(response.Patch, &patch); err != nil {
+				t.Fatalf("failed to decode patch: %v", err)
+			}
+			if !reflect.DeepEqual(patch, test.expected) {
+				t.Errorf("expected patch %+v, got %+v", test.expected, patch)
+			}
+		})
+	}
+}
+
+func TestServeHTTPInvalidRequest(t *testing.T) {
+	handler := newTestHandler(t, false)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, Path, nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405 for a GET request, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, Path, bytes.NewReader([]byte("{}"))))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a review without a request, got %d", recorder.Code)
+	}
+}
+
+func TestEscapeJSONPointer(t *testing.T) {
+	tests := map[string]string{
+		"app":                               "app",
+		"finance.statcan.gc.ca/workload-id": "finance.statcan.gc.ca~1workload-id",
+		"a~b/c":                             "a~0b~1c",
+	}
+
+	for token, expected := range tests {
+		if escaped := escapeJSONPointer(token); escaped != expected {
+			t.Errorf("expected %q to be escaped as %q, got %q", token, expected, escaped)
+		}
+	}
+}
+
+// writeKeyPair writes a self-signed certificate for the common name and
+// its key to the files.
+func writeKeyPair(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to encode key: %v", err)
+	}
+
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeKeyPair(t, certFile, keyFile, "first")
+
+	reloader := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.load(); err != nil {
+		t.Fatalf("failed to load certificate: %v", err)
+	}
+
+	commonName := func() string {
+		t.Helper()
+
+		certificate, err := reloader.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("failed to get certificate: %v", err)
+		}
+		parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			t.Fatalf("failed to parse certificate: %v", err)
+		}
+
+		return parsed.Subject.CommonName
+	}
+
+	if name := commonName(); name != "first" {
+		t.Errorf("expected the first certificate, got %q", name)
+	}
+
+	// The rotated certificate is served once its files change
+	writeKeyPair(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatalf("failed to update modification time: %v", err)
+		}
+	}
+	if name := commonName(); name != "second" {
+		t.Errorf("expected the rotated certificate, got %q", name)
+	}
+
+	// The previous certificate is kept while the files are invalid
+	if err := ioutil.WriteFile(certFile, []byte("invalid"), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	later = later.Add(time.Minute)
+	if err := os.Chtimes(certFile, later, later); err != nil {
+		t.Fatalf("failed to update modification time: %v", err)
+	}
+	if name := commonName(); name != "second" {
+		t.Errorf("expected the previous certificate to be kept, got %q", name)
+	}
+}
@@ -0,0 +1,214 @@
+// Package config defines the configuration file of the network controller.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the configuration of the network controller. It describes
+// the platform components referenced by the generated policies.
+type Config struct {
+	Istio IstioConfig `json:"istio"`
+	DNS   DNSConfig   `json:"dns"`
+}
+
+// IstioConfig selects the components of the service mesh.
+type IstioConfig struct {
+	// NamespaceSelector selects the namespaces of the mesh components.
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+
+	// IngressGatewaySelector selects the ingress gateway pods.
+	IngressGatewaySelector metav1.LabelSelector `json:"ingressGatewaySelector"`
+
+	// ControlPlaneIngressSelectors select the control plane pods
+	// which may connect to workloads (e.g., istiod).
+	ControlPlaneIngressSelectors []metav1.LabelSelector `json:"controlPlaneIngressSelectors"`
+
+	// ControlPlaneEgressSelectors select the control plane pods
+	// which workloads may connect to (e.g., istiod).
+	ControlPlaneEgressSelectors []metav1.LabelSelector `json:"controlPlaneEgressSelectors"`
+}
+
+// DNSConfig selects the cluster DNS service.
+type DNSConfig struct {
+	// NamespaceSelector selects the namespace of the DNS pods.
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+
+	// PodSelector selects the DNS pods.
+	PodSelector metav1.LabelSelector `json:"podSelector"`
+
+	// Ports served by the DNS pods.
+	Ports []Port `json:"ports"`
+}
+
+// Port is a protocol and port number.
+type Port struct {
+	Protocol corev1.Protocol `json:"protocol"`
+	Port     int32           `json:"port"`
+}
+
+// Default returns the configuration matching the components of the
+// platform at the time the controller was written.
+func Default() *Config {
+	istioNamespaceSelector := metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"install.operator.istio.io/owner-name": "istio",
+			"namespace.statcan.gc.ca/purpose":      "system",
+		},
+	}
+
+	pilotSelector := metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{
+				Key:      "istio",
+				Operator: metav1.LabelSelectorOpIn,
+				Values:   []string{"pilot"},
+			},
+		},
+	}
+
+	mixerSelector := metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{
+				Key:      "istio",
+				Operator: metav1.LabelSelectorOpIn,
+				Values:   []string{"mixer"},
+			},
+		},
+	}
+
+	return &Config{
+		Istio: IstioConfig{
+			NamespaceSelector: istioNamespaceSelector,
+			IngressGatewaySelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"istio": "ingressgateway",
+				},
+			},
+			ControlPlaneIngressSelectors: []metav1.LabelSelector{pilotSelector},
+			ControlPlaneEgressSelectors:  []metav1.LabelSelector{pilotSelector, mixerSelector},
+		},
+		DNS: DNSConfig{
+			NamespaceSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"kubernetes.io/cluster-service": "true",
+				},
+			},
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"k8s-app": "kube-dns",
+				},
+			},
+			Ports: []Port{
+				{Protocol: corev1.ProtocolUDP, Port: 53},
+				{Protocol: corev1.ProtocolTCP, Port: 53},
+			},
+		},
+	}
+}
+
+// configFile is the configuration file, in which every setting is
+// optional. A setting of the file replaces the default value as a whole.
+type configFile struct {
+	Istio struct {
+		NamespaceSelector            *metav1.LabelSelector  `json:"namespaceSelector"`
+		IngressGatewaySelector       *metav1.LabelSelector  `json:"ingressGatewaySelector"`
+		ControlPlaneIngressSelectors []metav1.LabelSelector `json:"controlPlaneIngressSelectors"`
+		ControlPlaneEgressSelectors  []metav1.LabelSelector `json:"controlPlaneEgressSelectors"`
+	} `json:"istio"`
+	DNS struct {
+		NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector"`
+		PodSelector       *metav1.LabelSelector `json:"podSelector"`
+		Ports             []Port                `json:"ports"`
+	} `json:"dns"`
+}
+
+// Load reads the configuration file at path. Settings missing from
+// the file keep their default value, while the settings of the file
+// replace the default value entirely (e.g., the labels of a selector
+// are not merged with the default labels). The configuration is validated.
+func Load(path string) (*Config, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file %q: %w", path, err)
+	}
+
+	file := &configFile{}
+	if err := yaml.UnmarshalStrict(contents, file); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration file %q: %w", path, err)
+	}
+
+	cfg := Default()
+	if file.Istio.NamespaceSelector != nil {
+		cfg.Istio.NamespaceSelector = *file.Istio.NamespaceSelector
+	}
+	if file.Istio.IngressGatewaySelector != nil {
+		cfg.Istio.IngressGatewaySelector = *file.Istio.IngressGatewaySelector
+	}
+	if file.Istio.ControlPlaneIngressSelectors != nil {
+		cfg.Istio.ControlPlaneIngressSelectors = file.Istio.ControlPlaneIngressSelectors
+	}
+	if file.Istio.ControlPlaneEgressSelectors != nil {
+		cfg.Istio.ControlPlaneEgressSelectors = file.Istio.ControlPlaneEgressSelectors
+	}
+	if file.DNS.NamespaceSelector != nil {
+		cfg.DNS.NamespaceSelector = *file.DNS.NamespaceSelector
+	}
+	if file.DNS.PodSelector != nil {
+		cfg.DNS.PodSelector = *file.DNS.PodSelector
+	}
+	if file.DNS.Ports != nil {
+		cfg.DNS.Ports = file.DNS.Ports
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %q: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Validate checks that the selectors and ports of the configuration are valid.
+func (c *Config) Validate() error {
+	selectors := map[string]*metav1.LabelSelector{
+		"istio.namespaceSelector":      &c.Istio.NamespaceSelector,
+		"istio.ingressGatewaySelector": &c.Istio.IngressGatewaySelector,
+		"dns.namespaceSelector":        &c.DNS.NamespaceSelector,
+		"dns.podSelector":              &c.DNS.PodSelector,
+	}
+	for i := range c.Istio.ControlPlaneIngressSelectors {
+		selectors[fmt.Sprintf("istio.controlPlaneIngressSelectors[%d]", i)] = &c.Istio.ControlPlaneIngressSelectors[i]
+	}
+	for i := range c.Istio.ControlPlaneEgressSelectors {
+		selectors[fmt.Sprintf("istio.controlPlaneEgressSelectors[%d]", i)] = &c.Istio.ControlPlaneEgressSelectors[i]
+	}
+
+	for field, selector := range selectors {
+		if _, err := metav1.LabelSelectorAsSelector(selector); err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+	}
+
+	if len(c.DNS.Ports) == 0 {
+		return fmt.Errorf("dns.ports: at least one port is required")
+	}
+
+	for i, port := range c.DNS.Ports {
+		switch port.Protocol {
+		case corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP:
+		default:
+			return fmt.Errorf("dns.ports[%d].protocol: unsupported protocol %q", i, port.Protocol)
+		}
+
+		if port.Port < 1 || port.Port > 65535 {
+			return fmt.Errorf("dns.ports[%d].port: %d is not a valid port number", i, port.Port)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLoadExample(t *testing.T) {
+	cfg, err := Load("../../../manifests/examples/network-config.yaml")
+	if err != nil {
+		t.Fatalf("failed to load the example configuration: %v", err)
+	}
+
+	istiod := []metav1.LabelSelector{
+		{MatchLabels: map[string]string{"app": "istiod"}},
+	}
+	if !reflect.DeepEqual(cfg.Istio.ControlPlaneIngressSelectors, istiod) {
+		t.Errorf("expected control plane ingress selectors %+v, got %+v", istiod, cfg.Istio.ControlPlaneIngressSelectors)
+	}
+	if !reflect.DeepEqual(cfg.Istio.ControlPlaneEgressSelectors, istiod) {
+		t.Errorf("expected control plane egress selectors %+v, got %+v", istiod, cfg.Istio.ControlPlaneEgressSelectors)
+	}
+
+	dnsNamespace := metav1.LabelSelector{
+		MatchLabels: map[string]string{"kubernetes.io/metadata.name": "kube-system"},
+	}
+	if !reflect.DeepEqual(cfg.DNS.NamespaceSelector, dnsNamespace) {
+		t.Errorf("expected DNS namespace selector %+v, got %+v", dnsNamespace, cfg.DNS.NamespaceSelector)
+	}
+}
+
+func TestLoadKeepsOmittedSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	contents := `
+dns:
+  podSelector:
+    matchLabels:
+      k8s-app: coredns
+`
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write configuration file: %v", err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	expected := Default()
+	expected.DNS.PodSelector = metav1.LabelSelector{
+		MatchLabels: map[string]string{"k8s-app": "coredns"},
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected configuration %+v, got %+v", expected, cfg)
+	}
+}
@@ -0,0 +1,133 @@
+package templates
+
+// renderOrder lists the templates rendered before the others, in order.
+// The default deny policy comes first so that a namespace is isolated before
+// its traffic is allowed, in the order the policies were created before they
+// were templated.
+var renderOrder = []string{
+	"default-deny",
+	"allow-same-namespace",
+	"allow-ingress-controller",
+	"allow-core-system",
+	"allow-kube-apiserver",
+}
+
+// DefaultTemplates is the built-in policy template set, used when no
+// template source is configured.
+var DefaultTemplates = map[string]string{
+	// Default deny all ingress and egress traffic
+	"default-deny.yaml": `apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: default-deny
+  namespace: {{ .Namespace.Name }}
+spec:
+  podSelector: {}
+  policyTypes:
+  - Ingress
+  - Egress
+`,
+
+	// Optionally allow traffic within the namespace
+	"allow-same-namespace.yaml": `{{- if .AllowSameNamespace -}}
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: allow-same-namespace
+  namespace: {{ .Namespace.Name }}
+spec:
+  podSelector: {}
+  policyTypes:
+  - Ingress
+  - Egress
+  ingress:
+  - from:
+    - podSelector: {}
+  egress:
+  - to:
+    - podSelector: {}
+{{- end }}
+`,
+
+	// Optionally allow traffic from the ingress gateway
+	"allow-ingress-controller.yaml": `{{- if .AllowIngressController -}}
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: allow-ingress-controller
+  namespace: {{ .Namespace.Name }}
+spec:
+  podSelector: {}
+  policyTypes:
+  - Ingress
+  ingress:
+  - from:
+    - namespaceSelector: {{ toJson .Config.Istio.NamespaceSelector }}
+      podSelector: {{ toJson .Config.Istio.IngressGatewaySelector }}
+{{- end }}
+`,
+
+	// Allow access to core system components necessary for standard operation
+	// (e.g., DNS)
+	"allow-core-system.yaml": `apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: allow-core-system
+  namespace: {{ .Namespace.Name }}
+spec:
+  podSelector: {}
+  policyTypes:
+  - Ingress
+  - Egress
+  ingress:
+{{- if .Config.Istio.ControlPlaneIngressSelectors }}
+  - from:
+{{- range .Config.Istio.ControlPlaneIngressSelectors }}
+    - namespaceSelector: {{ toJson $.Config.Istio.NamespaceSelector }}
+      podSelector: {{ toJson . }}
+{{- end }}
+{{- end }}
+  egress:
+  - to:
+    - namespaceSelector: {{ toJson .Config.DNS.NamespaceSelector }}
+      podSelector: {{ toJson .Config.DNS.PodSelector }}
+    ports: {{ toJson .Config.DNS.Ports }}
+{{- range .Config.Istio.ControlPlaneEgressSelectors }}
+  - to:
+    - namespaceSelector: {{ toJson $.Config.Istio.NamespaceSelector }}
+      podSelector: {{ toJson . }}
+{{- end }}
+`,
+
+	// Allow access to kube-apiserver to workloads with the necessary label.
+	// However, system namespaces will have this by default.
+	"allow-kube-apiserver.yaml": `apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: allow-kube-apiserver
+  namespace: {{ .Namespace.Name }}
+spec:
+{{- if .IsSystem }}
+  podSelector: {}
+{{- else }}
+  podSelector:
+    matchLabels:
+      network.statcan.gc.ca/allow-kube-apiserver: "true"
+{{- end }}
+  policyTypes:
+  - Egress
+  egress:
+{{- range .APIServer }}
+  - to:
+{{- range .CIDRs }}
+    - ipBlock:
+        cidr: {{ . }}
+{{- end }}
+    ports:
+{{- range .Ports }}
+    - protocol: {{ .Protocol }}
+      port: {{ .Port }}
+{{- end }}
+{{- end }}
+`,
+}
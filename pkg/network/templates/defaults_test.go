@@ -0,0 +1,217 @@
+package templates
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/StatCan/namespace-controller/pkg/network/config"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+// legacyPolicies returns the policies which were hard-coded in the
+// controller before the templates were introduced.
+func legacyPolicies(data *Data) map[string]*networkingv1.NetworkPolicy {
+	protocolTCP := corev1.ProtocolTCP
+	protocolUDP := corev1.ProtocolUDP
+	dnsPort := intstr.FromInt(53)
+
+	istioNamespaceSelector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"install.operator.istio.io/owner-name": "istio",
+			"namespace.statcan.gc.ca/purpose":      "system",
+		},
+	}
+	istioSelector := func(component string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "istio", Operator: metav1.LabelSelectorOpIn, Values: []string{component}},
+			},
+		}
+	}
+
+	policies := []*networkingv1.NetworkPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "default-deny"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "allow-core-system"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{
+					{
+						From: []networkingv1.NetworkPolicyPeer{
+							{NamespaceSelector: istioNamespaceSelector, PodSelector: istioSelector("pilot")},
+						},
+					},
+				},
+				Egress: []networkingv1.NetworkPolicyEgressRule{
+					{
+						To: []networkingv1.NetworkPolicyPeer{
+							{
+								NamespaceSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{"kubernetes.io/cluster-service": "true"},
+								},
+								PodSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{"k8s-app": "kube-dns"},
+								},
+							},
+						},
+						Ports: []networkingv1.NetworkPolicyPort{
+							{Protocol: &protocolUDP, Port: &dnsPort},
+							{Protocol: &protocolTCP, Port: &dnsPort},
+						},
+					},
+					{
+						To: []networkingv1.NetworkPolicyPeer{
+							{NamespaceSelector: istioNamespaceSelector, PodSelector: istioSelector("pilot")},
+						},
+					},
+					{
+						To: []networkingv1.NetworkPolicyPeer{
+							{NamespaceSelector: istioNamespaceSelector, PodSelector: istioSelector("mixer")},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if data.AllowSameNamespace {
+		policies = append(policies, &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "allow-same-namespace"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{
+					{From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}},
+				},
+				Egress: []networkingv1.NetworkPolicyEgressRule{
+					{To: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}},
+				},
+			},
+		})
+	}
+
+	if data.AllowIngressController {
+		policies = append(policies, &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "allow-ingress-controller"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{
+					{
+						From: []networkingv1.NetworkPolicyPeer{
+							{
+								NamespaceSelector: istioNamespaceSelector,
+								PodSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{"istio": "ingressgateway"},
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	apiServerPolicy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "allow-kube-apiserver"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+		},
+	}
+	if !data.IsSystem {
+		apiServerPolicy.Spec.PodSelector = metav1.LabelSelector{
+			MatchLabels: map[string]string{"network.statcan.gc.ca/allow-kube-apiserver": "true"},
+		}
+	}
+	for _, subset := range data.APIServer {
+		rule := networkingv1.NetworkPolicyEgressRule{}
+		for _, cidr := range subset.CIDRs {
+			rule.To = append(rule.To, networkingv1.NetworkPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{CIDR: cidr},
+			})
+		}
+		for _, port := range subset.Ports {
+			protocol := port.Protocol
+			portNum := intstr.FromInt(int(port.Port))
+			rule.Ports = append(rule.Ports, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &portNum})
+		}
+		apiServerPolicy.Spec.Egress = append(apiServerPolicy.Spec.Egress, rule)
+	}
+	policies = append(policies, apiServerPolicy)
+
+	byName := map[string]*networkingv1.NetworkPolicy{}
+	for _, policy := range policies {
+		policy.TypeMeta = metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "NetworkPolicy"}
+		policy.Namespace = data.Namespace.Name
+		byName[policy.Name] = policy
+	}
+
+	return byName
+}
+
+func TestDefaultReproducesLegacyPolicies(t *testing.T) {
+	apiServer := []EndpointSubset{
+		{
+			CIDRs: []string{"10.0.0.1/32", "fd00::1/128"},
+			Ports: []EndpointPort{{Protocol: corev1.ProtocolTCP, Port: 443}},
+		},
+	}
+
+	tests := []struct {
+		name string
+		data Data
+	}{
+		{
+			name: "default",
+		},
+		{
+			name: "system",
+			data: Data{IsSystem: true, AllowSameNamespace: true},
+		},
+		{
+			name: "allow",
+			data: Data{AllowSameNamespace: true, AllowIngressController: true},
+		},
+	}
+
+	set := Default()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := test.data
+			data.Namespace = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "alpha"}}
+			data.Config = config.Default()
+			data.APIServer = apiServer
+
+			expected := legacyPolicies(&data)
+
+			rendered := map[string]*networkingv1.NetworkPolicy{}
+			for _, name := range set.Names() {
+				policies, err := set.RenderTemplate(name, &data)
+				if err != nil {
+					t.Fatalf("failed to render template %s: %v", name, err)
+				}
+				for _, policy := range policies {
+					rendered[policy.Name] = policy
+				}
+			}
+
+			if !reflect.DeepEqual(rendered, expected) {
+				renderedYAML, _ := yaml.Marshal(rendered)
+				expectedYAML, _ := yaml.Marshal(expected)
+				t.Errorf("expected:\n%s\ngot:\n%s", expectedYAML, renderedYAML)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package templates
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+// Store holds the active template set and allows it to be swapped
+// at runtime when the template source changes.
+type Store struct {
+	mu  sync.RWMutex
+	set *Set
+
+	// onChange is called after a new set has been stored
+	onChange func(*Set)
+}
+
+// NewStore creates a store initialized with set. The onChange callback,
+// if non-nil, is invoked whenever the stored set changes.
+func NewStore(set *Set, onChange func(*Set)) *Store {
+	return &Store{
+		set:      set,
+		onChange: onChange,
+	}
+}
+
+// Get returns the active template set.
+func (s *Store) Get() *Set {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.set
+}
+
+// Update replaces the active template set. If the new set has the
+// same checksum as the active one, the update is ignored.
+func (s *Store) Update(set *Set) {
+	s.mu.Lock()
+	if s.set != nil && s.set.Checksum() == set.Checksum() {
+		s.mu.Unlock()
+		return
+	}
+	s.set = set
+	s.mu.Unlock()
+
+	klog.Infof("loaded policy template set %s (%d templates)", set.Checksum()[:12], len(set.names))
+	if s.onChange != nil {
+		s.onChange(set)
+	}
+}
+
+// WatchDirectory polls dir every interval and updates the store when
+// the templates it contains change. Failures to load the directory are
+// logged and the active set is kept. It blocks until ctx is cancelled.
+func (s *Store) WatchDirectory(ctx context.Context, dir string, interval time.Duration) {
+	wait.Until(func() {
+		set, err := LoadDirectory(dir)
+		if err != nil {
+			klog.Errorf("failed to reload policy templates from %q: %v", dir, err)
+			return
+		}
+
+		s.Update(set)
+	}, interval, ctx.Done())
+}
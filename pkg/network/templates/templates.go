@@ -0,0 +1,249 @@
+// Package templates renders NetworkPolicies for a namespace from a set of
+// YAML documents containing Go template placeholders.
+package templates
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	networkv1alpha1 "github.com/StatCan/namespace-controller/pkg/apis/network/v1alpha1"
+	"github.com/StatCan/namespace-controller/pkg/network/config"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Data is the information made available to each template when it is
+// rendered for a namespace.
+type Data struct {
+	// Namespace being rendered. Templates typically reference
+	// {{ .Namespace.Name }} and {{ .Namespace.Labels }}.
+	Namespace *corev1.Namespace
+
+	// Profile selected by the namespace, or nil when the namespace
+	// relies on the legacy network.statcan.gc.ca labels.
+	Profile *networkv1alpha1.NetworkProfile
+
+	// Config describes the platform components referenced by the policies.
+	Config *config.Config
+
+	// IsSystem is true for namespaces whose purpose is "system" or "daaas".
+	IsSystem bool
+
+	// AllowSameNamespace enables traffic between pods of the namespace.
+	AllowSameNamespace bool
+
+	// AllowIngressController enables ingress traffic from the ingress gateway.
+	AllowIngressController bool
+
+	// APIServer lists the addresses and ports of the Kubernetes API server.
+	APIServer []EndpointSubset
+}
+
+// EndpointSubset is a group of addresses sharing a common set of ports.
+type EndpointSubset struct {
+	// CIDRs contains one single-host CIDR (/32 or /128) per address.
+	CIDRs []string
+	Ports []EndpointPort
+}
+
+// EndpointPort is a port exposed by an endpoint.
+type EndpointPort struct {
+	Protocol corev1.Protocol
+	Port     int32
+}
+
+// Set is a parsed collection of policy templates.
+type Set struct {
+	names     []string
+	templates map[string]*template.Template
+	checksum  string
+}
+
+var funcs = template.FuncMap{
+	"toJson": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"quote": func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b)
+	},
+}
+
+// isTemplateFile returns true when name looks like a policy template.
+func isTemplateFile(name string) bool {
+	return strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml")
+}
+
+// sortNames orders the template names for rendering: the templates of
+// renderOrder first, followed by the others by name.
+func sortNames(names []string) {
+	rank := func(name string) int {
+		base := strings.TrimSuffix(strings.TrimSuffix(name, ".yaml"), ".yml")
+		for i, ordered := range renderOrder {
+			if base == ordered {
+				return i
+			}
+		}
+		return len(renderOrder)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if ri, rj := rank(names[i]), rank(names[j]); ri != rj {
+			return ri < rj
+		}
+		return names[i] < names[j]
+	})
+}
+
+// Parse parses the templates in sources, keyed by template name.
+// Entries whose name does not end in .yaml or .yml are ignored.
+func Parse(sources map[string]string) (*Set, error) {
+	set := &Set{
+		names:     []string{},
+		templates: map[string]*template.Template{},
+	}
+
+	for name := range sources {
+		if isTemplateFile(name) {
+			set.names = append(set.names, name)
+		}
+	}
+	sortNames(set.names)
+
+	if len(set.names) == 0 {
+		return nil, fmt.Errorf("no policy templates found")
+	}
+
+	hash := sha256.New()
+	for _, name := range set.names {
+		tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(sources[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse policy template %q: %w", name, err)
+		}
+
+		set.templates[name] = tmpl
+		fmt.Fprintf(hash, "%s\x00%s\x00", name, sources[name])
+	}
+	set.checksum = fmt.Sprintf("%x", hash.Sum(nil))
+
+	return set, nil
+}
+
+// Default returns the built-in template set.
+func Default() *Set {
+	set, err := Parse(DefaultTemplates)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse default policy templates: %v", err))
+	}
+
+	return set
+}
+
+// LoadDirectory reads and parses all templates found in dir.
+// Hidden files (such as the ..data links of a mounted ConfigMap)
+// are skipped.
+func LoadDirectory(dir string) (*Set, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy template directory %q: %w", dir, err)
+	}
+
+	sources := map[string]string{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || !isTemplateFile(entry.Name()) {
+			continue
+		}
+
+		// Stat the path, as ConfigMap volumes expose files through symlinks
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			continue
+		}
+
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy template %q: %w", path, err)
+		}
+		sources[entry.Name()] = string(contents)
+	}
+
+	return Parse(sources)
+}
+
+// Names returns the names of the templates in the set, in render order.
+func (s *Set) Names() []string {
+	return append([]string{}, s.names...)
+}
+
+// Checksum returns a digest of the template sources, which can be
+// used to detect when a reloaded set differs from the current one.
+func (s *Set) Checksum() string {
+	return s.checksum
+}
+
+// lookup finds a template by name, with or without its file extension.
+func (s *Set) lookup(name string) (*template.Template, bool) {
+	for _, candidate := range []string{name, name + ".yaml", name + ".yml"} {
+		if tmpl, ok := s.templates[candidate]; ok {
+			return tmpl, true
+		}
+	}
+
+	return nil, false
+}
+
+// RenderTemplate executes a single named template against data and decodes
+// the resulting YAML documents into NetworkPolicies. A template may produce
+// zero or more documents; empty documents are skipped.
+// The name may omit the .yaml or .yml extension.
+func (s *Set) RenderTemplate(name string, data *Data) ([]*networkingv1.NetworkPolicy, error) {
+	tmpl, ok := s.lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("policy template %q not found", name)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to execute policy template %q: %w", name, err)
+	}
+
+	policies := []*networkingv1.NetworkPolicy{}
+	decoder := yaml.NewYAMLOrJSONDecoder(&buf, 4096)
+	for {
+		policy := &networkingv1.NetworkPolicy{}
+		if err := decoder.Decode(policy); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to decode output of policy template %q: %w", name, err)
+		}
+
+		// Skip empty documents (e.g. from a disabled conditional block)
+		if policy.Name == "" && policy.Kind == "" {
+			continue
+		}
+
+		if policy.Name == "" {
+			return nil, fmt.Errorf("policy template %q produced a NetworkPolicy without a name", name)
+		}
+
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
@@ -0,0 +1,271 @@
+package templates
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testTemplate = `apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: {{ .Namespace.Name }}-policy
+spec:
+  podSelector: {}
+`
+
+func newTestData(labels map[string]string) *Data {
+	return &Data{
+		Namespace: &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "alpha", Labels: labels},
+		},
+	}
+}
+
+func TestParse(t *testing.T) {
+	set, err := Parse(map[string]string{
+		"b.yml":     testTemplate,
+		"a.yaml":    testTemplate,
+		"README.md": "not a template",
+	})
+	if err != nil {
+		t.Fatalf("failed to parse templates: %v", err)
+	}
+
+	expected := []string{"a.yaml", "b.yml"}
+	if !reflect.DeepEqual(set.Names(), expected) {
+		t.Errorf("expected templates %v, got %v", expected, set.Names())
+	}
+
+	// The checksum only depends on the templates
+	same, err := Parse(map[string]string{"a.yaml": testTemplate, "b.yml": testTemplate})
+	if err != nil {
+		t.Fatalf("failed to parse templates: %v", err)
+	}
+	if same.Checksum() != set.Checksum() {
+		t.Errorf("expected the checksum to ignore files which are not templates")
+	}
+
+	changed, err := Parse(map[string]string{"a.yaml": testTemplate, "b.yml": testTemplate + "\n"})
+	if err != nil {
+		t.Fatalf("failed to parse templates: %v", err)
+	}
+	if changed.Checksum() == set.Checksum() {
+		t.Errorf("expected the checksum to change with the templates")
+	}
+}
+
+func TestParseOrder(t *testing.T) {
+	sources := map[string]string{}
+	for _, name := range []string{"allow-kube-apiserver.yaml", "custom.yaml", "allow-same-namespace.yml", "default-deny.yaml", "allow-core-system.yaml", "allow-custom.yaml"} {
+		sources[name] = testTemplate
+	}
+
+	set, err := Parse(sources)
+	if err != nil {
+		t.Fatalf("failed to parse templates: %v", err)
+	}
+
+	// The default deny policy is rendered first
+	expected := []string{"default-deny.yaml", "allow-same-namespace.yml", "allow-core-system.yaml", "allow-kube-apiserver.yaml", "allow-custom.yaml", "custom.yaml"}
+	if !reflect.DeepEqual(set.Names(), expected) {
+		t.Errorf("expected templates %v, got %v", expected, set.Names())
+	}
+
+	expected = []string{"default-deny.yaml", "allow-same-namespace.yaml", "allow-ingress-controller.yaml", "allow-core-system.yaml", "allow-kube-apiserver.yaml"}
+	if names := Default().Names(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected default templates %v, got %v", expected, names)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources map[string]string
+		err     string
+	}{
+		{
+			name:    "no templates",
+			sources: map[string]string{"README.md": testTemplate},
+			err:     "no policy templates found",
+		},
+		{
+			name:    "syntax",
+			sources: map[string]string{"a.yaml": "{{ if .IsSystem }}"},
+			err:     `failed to parse policy template "a.yaml"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(test.sources)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	set, err := Parse(map[string]string{
+		// Disabled conditional blocks produce empty documents
+		"conditional.yaml": `{{- if .AllowSameNamespace -}}
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: conditional
+spec:
+  podSelector: {}
+{{- end }}
+`,
+		"multiple.yaml": `---
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: first
+spec:
+  podSelector: {}
+---
+{{- if .IsSystem }}
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: system
+spec:
+  podSelector: {}
+{{- end }}
+---
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: {{ .Namespace.Labels.team }}
+spec:
+  podSelector: {}
+`,
+		"unnamed.yaml": `apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+spec:
+  podSelector: {}
+`,
+	})
+	if err != nil {
+		t.Fatalf("failed to parse templates: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		data     *Data
+		expected []string
+		err      string
+	}{
+		{
+			name:     "disabled conditional",
+			template: "conditional.yaml",
+			data:     newTestData(nil),
+			expected: []string{},
+		},
+		{
+			// The extension of the template may be omitted
+			name:     "enabled conditional",
+			template: "conditional",
+			data:     &Data{Namespace: newTestData(nil).Namespace, AllowSameNamespace: true},
+			expected: []string{"conditional"},
+		},
+		{
+			name:     "multiple documents",
+			template: "multiple",
+			data:     newTestData(map[string]string{"team": "blue"}),
+			expected: []string{"first", "blue"},
+		},
+		{
+			name:     "missing key",
+			template: "multiple",
+			data:     newTestData(nil),
+			err:      `failed to execute policy template "multiple"`,
+		},
+		{
+			name:     "unnamed",
+			template: "unnamed",
+			data:     newTestData(nil),
+			err:      `policy template "unnamed" produced a NetworkPolicy without a name`,
+		},
+		{
+			name:     "not found",
+			template: "missing",
+			data:     newTestData(nil),
+			err:      `policy template "missing" not found`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policies, err := set.RenderTemplate(test.template, test.data)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to render template: %v", err)
+			}
+
+			names := []string{}
+			for _, policy := range policies {
+				names = append(names, policy.Name)
+			}
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("expected policies %v, got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestLoadDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	// Mounted ConfigMaps expose their keys as links into a hidden directory
+	dataDir := filepath.Join(dir, "..2020_01_01")
+	if err := os.Mkdir(dataDir, 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "nested.yaml"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	files := map[string]string{
+		filepath.Join(dataDir, "linked.yaml"): testTemplate,
+		filepath.Join(dir, "a.yaml"):          testTemplate,
+		filepath.Join(dir, ".hidden.yaml"):    "{{ invalid",
+		filepath.Join(dir, "README.md"):       "{{ invalid",
+	}
+	for path, contents := range files {
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	if err := os.Symlink(filepath.Join("..2020_01_01", "linked.yaml"), filepath.Join(dir, "linked.yaml")); err != nil {
+		t.Fatalf("failed to create link: %v", err)
+	}
+
+	set, err := LoadDirectory(dir)
+	if err != nil {
+		t.Fatalf("failed to load templates: %v", err)
+	}
+
+	expected := []string{"a.yaml", "linked.yaml"}
+	if !reflect.DeepEqual(set.Names(), expected) {
+		t.Errorf("expected templates %v, got %v", expected, set.Names())
+	}
+
+	if _, err := LoadDirectory(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected an error loading a missing directory")
+	}
+}
@@ -0,0 +1,102 @@
+// Package server serves the health checks and the Prometheus metrics
+// of the controllers.
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog"
+)
+
+// Paths served by the server
+const (
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+	MetricsPath = "/metrics"
+)
+
+// Server serves the health checks and the metrics of a registry.
+// The server is ready once SetReady is called, after the informer
+// caches are synced.
+type Server struct {
+	addr    string
+	handler http.Handler
+
+	// ready is set to 1 once the server is ready
+	ready int32
+}
+
+// New creates a server serving the metrics of registry on addr.
+func New(addr string, registry *prometheus.Registry) *Server {
+	s := &Server{addr: addr}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(HealthzPath, s.healthz)
+	mux.HandleFunc(ReadyzPath, s.readyz)
+	mux.Handle(MetricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	s.handler = mux
+
+	return s
+}
+
+// SetReady marks the server as ready. It is a no-op on a nil server,
+// so that it can be called when the server is disabled.
+func (s *Server) SetReady() {
+	if s == nil {
+		return
+	}
+
+	atomic.StoreInt32(&s.ready, 1)
+}
+
+// Handler returns the handler of the server.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// Serve serves the health checks and the metrics until ctx is cancelled.
+func (s *Server) Serve(ctx context.Context) error {
+	server := &http.Server{
+		Addr:    s.addr,
+		Handler: s.handler,
+	}
+
+	ShutdownOnDone(ctx, server)
+
+	klog.Infof("serving health checks and metrics on %s", s.addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// ShutdownOnDone shuts the server down once ctx is cancelled. The requests
+// in flight are given time to complete.
+func ShutdownOnDone(ctx context.Context, server *http.Server) {
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.ready) == 0 {
+		http.Error(w, "informer caches are not synced", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Write([]byte("ok"))
+}
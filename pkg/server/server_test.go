@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func get(t *testing.T, handler http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder
+}
+
+func TestHealthChecks(t *testing.T) {
+	s := New(":0", prometheus.NewRegistry())
+
+	if code := get(t, s.Handler(), HealthzPath).Code; code != http.StatusOK {
+		t.Errorf("expected %s to return %d, got %d", HealthzPath, http.StatusOK, code)
+	}
+	if code := get(t, s.Handler(), ReadyzPath).Code; code != http.StatusServiceUnavailable {
+		t.Errorf("expected %s to return %d before the caches are synced, got %d", ReadyzPath, http.StatusServiceUnavailable, code)
+	}
+
+	s.SetReady()
+	if code := get(t, s.Handler(), ReadyzPath).Code; code != http.StatusOK {
+		t.Errorf("expected %s to return %d once ready, got %d", ReadyzPath, http.StatusOK, code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_total", Help: "Test counter."})
+	registry.MustRegister(counter)
+	counter.Inc()
+
+	resp := get(t, New(":0", registry).Handler(), MetricsPath)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected %s to return %d, got %d", MetricsPath, http.StatusOK, resp.Code)
+	}
+	if !strings.Contains(resp.Body.String(), "test_total 1") {
+		t.Errorf("expected the metrics of the registry, got:\n%s", resp.Body.String())
+	}
+}
+
+func TestNilServer(t *testing.T) {
+	var s *Server
+	s.SetReady()
+}